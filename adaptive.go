@@ -0,0 +1,131 @@
+package serial
+
+import (
+	"time"
+)
+
+// Window over which errors are counted for adaptive bit rate selection.
+const adaptiveWindow = time.Second
+
+// State of adaptive bit rate selection.
+type adaptiveBaud struct {
+	candidates []int
+	next       int
+	threshold  int
+	onChange   func(bitrate int)
+	errors     int
+	since      time.Time
+}
+
+// Step down to a lower bit rate when the line is too noisy for the
+// current one, as on a long cable. Parity and framing errors are
+// counted as data is read, and when errorThreshold errors are received
+// within a second, the port is switched to the next of the candidate
+// bit rates, given fastest first, pending input is discarded, and
+// onChange, if not nil, is called with the new bit rate from the read
+// that triggered the change. Once the last candidate is reached, the
+// bit rate is no longer changed. The peer must follow the change by
+// some other means, such as detecting the bit rate itself.
+//
+// Parity checking is turned on and errors are marked in the input by
+// the driver, as for SetAbortOnParityError; the bytes in error are
+// dropped from the data read. Returns ErrUnsupportedOperation on
+// platforms without termios support; only Linux is currently
+// supported.
+func (p *Port) EnableAdaptiveBaud(candidates []int, errorThreshold int, onChange func(bitrate int)) error {
+	if len(candidates) == 0 || errorThreshold <= 0 {
+		return ErrInvalidArguments
+	}
+	for _, rate := range candidates {
+		if rate <= 0 {
+			return ErrInvalidArguments
+		}
+	}
+
+	a := &adaptiveBaud{
+		candidates: append([]int(nil), candidates...),
+		threshold:  errorThreshold,
+		onChange:   onChange,
+	}
+
+	// continue from the current bit rate if it is a candidate
+	if rate, err := p.BitRate(); err != nil {
+		return err
+	} else {
+		for i, c := range a.candidates {
+			if c == rate {
+				a.next = i + 1
+				break
+			}
+		}
+	}
+
+	if err := p.setParityCheck(true); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	if p.adaptive == nil {
+		p.markState = 0
+	}
+	p.adaptive = a
+	p.mu.Unlock()
+
+	return nil
+}
+
+// Stop adaptive bit rate selection. The current bit rate is kept, and
+// parity checking is turned off unless SetAbortOnParityError is in
+// effect.
+func (p *Port) DisableAdaptiveBaud() error {
+	p.mu.Lock()
+	adaptive, abort := p.adaptive != nil, p.parityAbort
+	p.mu.Unlock()
+
+	if !adaptive {
+		return nil
+	}
+	if !abort {
+		if err := p.setParityCheck(false); err != nil {
+			return err
+		}
+	}
+
+	p.mu.Lock()
+	p.adaptive = nil
+	p.mu.Unlock()
+	return nil
+}
+
+// Count errors received, stepping down the bit rate when the threshold
+// is reached. Called by the reader, which alone uses the counts.
+func (a *adaptiveBaud) count(p *Port, errs int) error {
+	now := time.Now()
+	if now.Sub(a.since) > adaptiveWindow {
+		a.errors, a.since = 0, now
+	}
+
+	if a.errors += errs; a.errors < a.threshold || a.next >= len(a.candidates) {
+		return nil
+	}
+
+	rate := a.candidates[a.next]
+	a.next++
+	a.errors, a.since = 0, now
+
+	if err := p.Apply(&Options{BitRate: rate}); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.markState = 0
+	p.mu.Unlock()
+	if err := p.ResetInput(); err != nil {
+		return err
+	}
+
+	if a.onChange != nil {
+		a.onChange(rate)
+	}
+
+	return nil
+}
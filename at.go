@@ -0,0 +1,46 @@
+package serial
+
+import (
+	"strings"
+	"time"
+)
+
+// Send an AT command to a modem and read its response. "AT" and cmd
+// are written followed by a carriage return, pending input having been
+// discarded, and lines are read until the final "OK" or error result,
+// within timeout; zero waits forever. The intermediate response lines
+// are returned, without the echo of the command and blank lines. An
+// "ERROR", "+CME ERROR" or "+CMS ERROR" result returns ErrATCommand,
+// with the result as the last line.
+func (p *Port) ATCommand(cmd string, timeout time.Duration) (lines []string, err error) {
+	deadline := timeoutDeadline(timeout)
+
+	if err = p.ResetInput(); err != nil {
+		return
+	}
+
+	cmd = "AT" + cmd
+	if _, err = p.write([]byte(cmd+"\r"), deadline); err != nil {
+		return
+	}
+
+	for {
+		b, err := p.readUntil([]byte("\n"), deadline)
+		if err != nil {
+			return lines, err
+		}
+
+		line := strings.TrimSpace(string(b))
+		switch {
+		case line == "" || line == cmd:
+			// blank line or echo
+		case line == "OK":
+			return lines, nil
+		case line == "ERROR" || strings.HasPrefix(line, "+CME ERROR") ||
+			strings.HasPrefix(line, "+CMS ERROR"):
+			return append(lines, line), ErrATCommand
+		default:
+			lines = append(lines, line)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package serial
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+)
+
+// Magic bytes that begin a capture.
+const captureMagic = "GOSERCP1"
+
+// Capture record directions.
+const (
+	CAPTURE_RX = 0 // data received from the port
+	CAPTURE_TX = 1 // data written to the port
+)
+
+// Capture of the traffic on a port.
+type capture struct {
+	mu  sync.Mutex
+	w   io.Writer
+	err error // first error writing the capture
+}
+
+// Record the traffic on the port to w, for replay or analysis in other
+// tools. The capture begins with the 8 bytes "GOSERCP1", followed by a
+// record for each read from and write to the port, each made with a
+// single write to w:
+//
+//	timestamp  8 bytes  nanoseconds since the Unix epoch, big endian
+//	direction  1 byte   CAPTURE_RX or CAPTURE_TX
+//	length     4 bytes  number of data bytes, big endian
+//	data       length bytes
+//
+// Data received is recorded as the driver delivers it, after parity
+// error marks are removed and before the echo of written data is
+// cancelled. A capture already in progress is stopped. If writing to w
+// fails, capturing stops and the error is returned from StopCapture.
+func (p *Port) StartCapture(w io.Writer) error {
+	p.capture.mu.Lock()
+	defer p.capture.mu.Unlock()
+
+	if _, err := io.WriteString(w, captureMagic); err != nil {
+		return err
+	}
+	p.capture.w = w
+	p.capture.err = nil
+
+	return nil
+}
+
+// Stop recording the traffic on the port, returning the first error
+// writing the capture, if any.
+func (p *Port) StopCapture() error {
+	p.capture.mu.Lock()
+	defer p.capture.mu.Unlock()
+
+	err := p.capture.err
+	p.capture.w = nil
+	p.capture.err = nil
+
+	return err
+}
+
+// Write a capture record, if capturing.
+func (c *capture) record(dir int, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.w == nil {
+		return
+	}
+
+	rec := make([]byte, 13+len(b))
+	binary.BigEndian.PutUint64(rec[0:8], uint64(time.Now().UnixNano()))
+	rec[8] = byte(dir)
+	binary.BigEndian.PutUint32(rec[9:13], uint32(len(b)))
+	copy(rec[13:], b)
+
+	if _, err := c.w.Write(rec); err != nil {
+		c.w, c.err = nil, err
+	}
+}
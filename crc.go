@@ -0,0 +1,137 @@
+package serial
+
+import (
+	"io"
+)
+
+var crc16ModbusTable = makeCRC16ReflectedTable(0xA001)
+var crc16CCITTTable = makeCRC16Table(0x1021)
+var crc8Table = makeCRC8Table(0x07)
+
+func makeCRC16ReflectedTable(poly uint16) *[256]uint16 {
+	t := new([256]uint16)
+	for i := range t {
+		crc := uint16(i)
+		for j := 0; j < 8; j++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ poly
+			} else {
+				crc >>= 1
+			}
+		}
+		t[i] = crc
+	}
+	return t
+}
+
+func makeCRC16Table(poly uint16) *[256]uint16 {
+	t := new([256]uint16)
+	for i := range t {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ poly
+			} else {
+				crc <<= 1
+			}
+		}
+		t[i] = crc
+	}
+	return t
+}
+
+func makeCRC8Table(poly uint8) *[256]uint8 {
+	t := new([256]uint8)
+	for i := range t {
+		crc := uint8(i)
+		for j := 0; j < 8; j++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ poly
+			} else {
+				crc <<= 1
+			}
+		}
+		t[i] = crc
+	}
+	return t
+}
+
+// Compute the CRC-16/MODBUS checksum (polynomial 0x8005 reflected,
+// initial value 0xFFFF) used by Modbus RTU. Modbus transmits the
+// checksum low byte first.
+func CRC16Modbus(b []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, c := range b {
+		crc = crc>>8 ^ crc16ModbusTable[byte(crc)^c]
+	}
+	return crc
+}
+
+// Compute the CRC-16/CCITT-FALSE checksum (polynomial 0x1021, initial
+// value 0xFFFF, not reflected).
+func CRC16CCITT(b []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, c := range b {
+		crc = crc<<8 ^ crc16CCITTTable[byte(crc>>8)^c]
+	}
+	return crc
+}
+
+// Compute the CRC-8 checksum (polynomial 0x07, initial value 0x00, not
+// reflected).
+func CRC8(b []byte) uint8 {
+	crc := uint8(0)
+	for _, c := range b {
+		crc = crc8Table[crc^c]
+	}
+	return crc
+}
+
+// Frame writer that appends a checksum to each frame written to it.
+type CRCWriter struct {
+	w   io.Writer
+	sum func([]byte) []byte
+}
+
+// Create a writer that appends the CRC-16/MODBUS checksum to each
+// frame, low byte first.
+func NewCRC16ModbusWriter(w io.Writer) *CRCWriter {
+	return &CRCWriter{w: w, sum: func(b []byte) []byte {
+		crc := CRC16Modbus(b)
+		return []byte{byte(crc), byte(crc >> 8)}
+	}}
+}
+
+// Create a writer that appends the CRC-16/CCITT-FALSE checksum to each
+// frame, high byte first.
+func NewCRC16CCITTWriter(w io.Writer) *CRCWriter {
+	return &CRCWriter{w: w, sum: func(b []byte) []byte {
+		crc := CRC16CCITT(b)
+		return []byte{byte(crc >> 8), byte(crc)}
+	}}
+}
+
+// Create a writer that appends the CRC-8 checksum to each frame.
+func NewCRC8Writer(w io.Writer) *CRCWriter {
+	return &CRCWriter{w: w, sum: func(b []byte) []byte {
+		return []byte{CRC8(b)}
+	}}
+}
+
+// Write the frame followed by its checksum in a single write to the
+// underlying writer. Returns the number of frame bytes written, not
+// counting the checksum.
+func (w *CRCWriter) Write(b []byte) (int, error) {
+	sum := w.sum(b)
+	frame := make([]byte, 0, len(b)+len(sum))
+	frame = append(append(frame, b...), sum...)
+
+	n, err := w.w.Write(frame)
+	if n > len(b) {
+		n = len(b)
+	}
+	if err == nil && n < len(b) {
+		err = io.ErrShortWrite
+	}
+	return n, err
+}
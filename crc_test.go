@@ -0,0 +1,45 @@
+package serial
+
+import (
+	"bytes"
+	"testing"
+)
+
+var crcCheck = []byte("123456789")
+
+// The checksums match the published check values, the checksum of the
+// ASCII digits "123456789".
+func TestCRCCheck(t *testing.T) {
+	if got := CRC16CCITT(crcCheck); got != 0x29b1 {
+		t.Errorf("CRC16CCITT: got %#04x, want 0x29b1", got)
+	}
+	if got := CRC16Modbus(crcCheck); got != 0x4b37 {
+		t.Errorf("CRC16Modbus: got %#04x, want 0x4b37", got)
+	}
+	if got := CRC8(crcCheck); got != 0xf4 {
+		t.Errorf("CRC8: got %#02x, want 0xf4", got)
+	}
+}
+
+// The writers append the checksum in the byte order of the protocol.
+func TestCRCWriter(t *testing.T) {
+	tests := []struct {
+		name string
+		new  func(w *bytes.Buffer) *CRCWriter
+		sum  []byte
+	}{
+		{"modbus", func(w *bytes.Buffer) *CRCWriter { return NewCRC16ModbusWriter(w) }, []byte{0x37, 0x4b}},
+		{"ccitt", func(w *bytes.Buffer) *CRCWriter { return NewCRC16CCITTWriter(w) }, []byte{0x29, 0xb1}},
+		{"crc8", func(w *bytes.Buffer) *CRCWriter { return NewCRC8Writer(w) }, []byte{0xf4}},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		n, err := tt.new(&buf).Write(crcCheck)
+		if n != len(crcCheck) || err != nil {
+			t.Errorf("%s: got %d, %v", tt.name, n, err)
+		}
+		if want := append(append([]byte{}, crcCheck...), tt.sum...); !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("%s: got % x, want % x", tt.name, buf.Bytes(), want)
+		}
+	}
+}
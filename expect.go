@@ -0,0 +1,77 @@
+package serial
+
+import (
+	"bytes"
+	"time"
+)
+
+// Read until the pattern appears in the input or the timeout expires,
+// discarding the data read up to and including the pattern. This is
+// the expect primitive for scripting device dialogs, such as waiting
+// for "login:" or "OK". A timeout of zero waits forever.
+func (p *Port) Expect(pattern []byte, timeout time.Duration) error {
+	_, _, err := p.expect([][]byte{pattern}, timeout, false)
+	return err
+}
+
+// Read until any of the patterns appears in the input or the timeout
+// expires, for device dialogs that branch, such as "OK" or "ERROR".
+// Returns the index of the pattern that matched and the data read up
+// to and including it. The pattern that ends first in the input wins;
+// if several end at the same byte, as when one pattern is a suffix of
+// another, the one with the lowest index wins. The data read is
+// limited by the maximum read size. A timeout of zero waits forever.
+// On error, the index is -1 and the data read so far is returned.
+func (p *Port) ExpectAny(patterns [][]byte, timeout time.Duration) (int, []byte, error) {
+	return p.expect(patterns, timeout, true)
+}
+
+// Read until any of the patterns appears in the input. Unless keep is
+// set, only the data needed to match the longest pattern is kept.
+func (p *Port) expect(patterns [][]byte, timeout time.Duration, keep bool) (int, []byte, error) {
+	if p.mode&MODE_READ == 0 {
+		return -1, nil, ErrNotReadable
+	}
+
+	longest := 0
+	for i, pattern := range patterns {
+		if len(pattern) == 0 {
+			return i, []byte{}, nil
+		} else if len(pattern) > longest {
+			longest = len(pattern)
+		}
+	}
+	if longest == 0 {
+		return -1, nil, ErrInvalidArguments
+	}
+
+	deadline := timeoutDeadline(timeout)
+
+	var buf []byte
+	max := p.maxReadSize()
+	for {
+		for p.buffered() > 0 {
+			if keep && max > 0 && len(buf) >= max {
+				return -1, buf, ErrTooLong
+			}
+			c, ok := p.nextBuffered()
+			if !ok {
+				break
+			}
+			if !keep && len(buf) == longest {
+				// slide a window the size of the longest pattern
+				copy(buf, buf[1:])
+				buf = buf[:len(buf)-1]
+			}
+			buf = append(buf, c)
+			for i, pattern := range patterns {
+				if bytes.HasSuffix(buf, pattern) {
+					return i, buf, nil
+				}
+			}
+		}
+		if err := p.fill(deadline); err != nil {
+			return -1, buf, err
+		}
+	}
+}
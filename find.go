@@ -0,0 +1,73 @@
+package serial
+
+// Filter for finding USB serial adapter ports. Zero fields match any
+// port.
+type PortFilter struct {
+	VID    int    // USB vendor ID
+	PID    int    // USB product ID
+	Serial string // USB serial number
+}
+
+// Port found by FindPorts, with the filter fields it was matched on.
+// A field is true if it was set in the filter and matched the port, so
+// that the attribute that selected a port among several similar ones
+// can be logged.
+type PortMatch struct {
+	*Info
+	VID    bool
+	PID    bool
+	Serial bool
+}
+
+// Find the ports that match all fields set in the filter. Ports that
+// are not USB adapters only match an empty filter.
+func FindPorts(filter PortFilter) ([]PortMatch, error) {
+	ports, err := ListPorts()
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []PortMatch
+	for _, info := range ports {
+		if m, ok := filter.match(info); ok {
+			matches = append(matches, m)
+		}
+	}
+
+	return matches, nil
+}
+
+// Match a port against the filter.
+func (f *PortFilter) match(info *Info) (m PortMatch, ok bool) {
+	m.Info = info
+
+	if f.VID == 0 && f.PID == 0 && f.Serial == "" {
+		return m, true
+	}
+
+	vid, pid, err := info.USBVIDPID()
+	if err != nil {
+		return m, false
+	}
+
+	if f.VID != 0 {
+		if vid != f.VID {
+			return m, false
+		}
+		m.VID = true
+	}
+	if f.PID != 0 {
+		if pid != f.PID {
+			return m, false
+		}
+		m.PID = true
+	}
+	if f.Serial != "" {
+		if info.USBSerialNumber() != f.Serial {
+			return m, false
+		}
+		m.Serial = true
+	}
+
+	return m, true
+}
@@ -0,0 +1,260 @@
+package serial
+
+import (
+	"bytes"
+	"errors"
+	"math/bits"
+	"time"
+)
+
+// Best-effort guess at the framing of incoming data.
+type FramingGuess struct {
+	DataBits int    // number of data bits (7, 8)
+	Parity   Parity // none, odd, even, mark
+	StopBits int    // number of stop bits
+	Samples  int    // number of bytes the guess is based on
+	Errors   int    // number of parity and framing errors among them
+}
+
+// Candidate framings tried by AutoDetectFraming, in order of
+// preference when they score equally. The framings with a parity bit
+// come first, as 8N1 reports no parity errors.
+var framingCandidates = []struct {
+	bits   int
+	parity Parity
+}{
+	{7, PARITY_EVEN},
+	{7, PARITY_ODD},
+	{7, PARITY_MARK},
+	{8, PARITY_EVEN},
+	{8, PARITY_ODD},
+	{8, PARITY_NONE},
+}
+
+// Sample incoming data at each candidate framing in turn and guess its
+// data bits and parity. The candidates are 7 and 8 data bits with even,
+// odd or mark parity, and 8N1, all at the current bit rate; each is
+// sampled for an equal share of timeout with parity checking enabled
+// and the parity and framing errors marked in the input, and the
+// framing with the fewest errors for the bytes received is reported.
+// The configuration and terminal attributes of the port are restored
+// afterwards.
+//
+// The number of stop bits cannot be told apart, as a receiver checks
+// only the first, and is reported as 1. On platforms without termios
+// support, where line errors cannot be counted, the data is sampled as
+// 8N1 only and the framing guessed from the parity and high bits of
+// each byte. The more bytes sampled, the more reliable the guess.
+// Returns ErrTimeout if no data arrives. A timeout of zero waits
+// forever, sampling each framing until enough bytes have arrived.
+func (p *Port) AutoDetectFraming(timeout time.Duration) (guess FramingGuess, err error) {
+	guess = FramingGuess{DataBits: 8, Parity: PARITY_NONE, StopBits: 1}
+
+	p.cmu.Lock()
+	defer p.cmu.Unlock()
+
+	saved, err := p.saveConf()
+	if err != nil {
+		return guess, err
+	}
+	defer func() {
+		if rerr := p.restoreConf(saved); err == nil {
+			err = rerr
+		}
+	}()
+
+	t, err := p.getTermios()
+	if errors.Is(err, ErrUnsupportedOperation) {
+		return p.sampleFraming(guess, timeout)
+	} else if err != nil {
+		return guess, err
+	}
+	defer func() {
+		if rerr := p.applyTermios(t); err == nil {
+			err = rerr
+		}
+	}()
+	if err := p.markErrors(); err != nil {
+		return guess, err
+	}
+
+	found := false
+	share := timeout / time.Duration(len(framingCandidates))
+	for _, f := range framingCandidates {
+		options := Options{DataBits: f.bits, Parity: f.parity, StopBits: 1}
+		if err := p.apply(&options); errors.Is(err, ErrUnsupportedOperation) {
+			continue
+		} else if err != nil {
+			return guess, err
+		}
+
+		n, errs, err := p.countErrors(timeoutDeadline(share))
+		if err != nil {
+			return guess, err
+		}
+
+		// compare error rates, keeping the earlier candidate on a tie
+		if n > 0 && (!found || errs*guess.Samples < guess.Errors*n) {
+			guess.DataBits, guess.Parity = f.bits, f.parity
+			guess.Samples, guess.Errors = n, errs
+			found = true
+		}
+	}
+
+	if !found {
+		return guess, ErrTimeout
+	}
+	return guess, nil
+}
+
+// Discard pending input and sample input with errors marked until the
+// deadline or until enough bytes have been received, returning the
+// number of bytes and errors received. Must be called with cmu held.
+func (p *Port) countErrors(deadline time.Time) (int, int, error) {
+	if err := p.ResetInput(); err != nil {
+		return 0, 0, err
+	}
+
+	buf := make([]byte, 256)
+	total, errs, state := 0, 0, 0
+	for total+errs < len(buf) {
+		n, err := p.readDevice(buf, deadline, READ_FIRST_BYTE, false)
+		n, e := decodeMarks(buf[:n], &state, false)
+		total, errs = total+n, errs+e
+		if err == ErrTimeout {
+			break
+		} else if err != nil {
+			return total, errs, err
+		}
+	}
+	return total, errs, nil
+}
+
+// Sample incoming data as 8N1 and guess its framing from the bytes
+// received. Must be called with cmu held.
+func (p *Port) sampleFraming(guess FramingGuess, timeout time.Duration) (FramingGuess, error) {
+	options := Options{DataBits: 8, Parity: PARITY_NONE, StopBits: 1}
+	if err := p.apply(&options); err != nil {
+		return guess, err
+	}
+	if err := p.ResetInput(); err != nil {
+		return guess, err
+	}
+
+	// sample until the buffer is full or the timeout expires
+	deadline := timeoutDeadline(timeout)
+	buf := make([]byte, 256)
+	n := 0
+	for n < len(buf) {
+		c, err := p.readDevice(buf[n:], deadline, READ_FIRST_BYTE, false)
+		n += c
+		if err == ErrTimeout {
+			break
+		} else if err != nil {
+			return guess, err
+		}
+	}
+
+	guess.Samples = n
+	if n == 0 {
+		return guess, ErrTimeout
+	}
+
+	guess.DataBits, guess.Parity = guessFraming(buf[:n])
+
+	return guess, nil
+}
+
+// Send a probe and check that the response matches the expected bytes
+// at the current framing, to confirm the line is configured correctly
+// before trusting its data. Pending input is discarded first. Returns
+// false if the response differs from expected or is incomplete when
+// the timeout expires, and ErrTimeout if no response arrives at all. A
+// timeout of zero waits forever.
+func (p *Port) VerifyFraming(expected, probe []byte, timeout time.Duration) (bool, error) {
+	if err := p.ResetInput(); err != nil {
+		return false, err
+	}
+
+	deadline := timeoutDeadline(timeout)
+	if len(probe) > 0 {
+		if _, err := p.write(probe, deadline); err != nil {
+			return false, err
+		}
+	}
+
+	buf := make([]byte, len(expected))
+	n := 0
+	for n < len(buf) {
+		c, err := p.read(buf[n:], deadline, READ_FIRST_BYTE)
+		n += c
+		if !bytes.Equal(buf[:n], expected[:n]) {
+			return false, nil
+		}
+		if err == ErrTimeout {
+			if n == 0 {
+				return false, err
+			}
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// Read and discard input until the line has been idle for the given
+// span, to find a frame boundary after losing sync mid-stream, such as
+// after a checksum failure. Returns ErrTimeout if the line does not go
+// idle within timeout. A timeout of zero waits forever.
+func (p *Port) Resync(idle, timeout time.Duration) error {
+	if idle <= 0 {
+		return ErrInvalidArguments
+	}
+
+	deadline := timeoutDeadline(timeout)
+
+	p.dropBuffered()
+	buf := make([]byte, 256)
+	for {
+		until := time.Now().Add(idle)
+		if !deadline.IsZero() && deadline.Before(until) {
+			until = deadline
+		}
+
+		n, err := p.read(buf, until, READ_FIRST_BYTE)
+		if err == ErrTimeout && n == 0 {
+			if until == deadline {
+				return ErrTimeout
+			}
+			return nil
+		} else if err != nil && err != ErrTimeout {
+			return err
+		}
+	}
+}
+
+// Guess data bits and parity from bytes received as 8N1.
+func guessFraming(b []byte) (int, Parity) {
+	even, high := 0, 0
+	for _, c := range b {
+		if bits.OnesCount8(c)%2 == 0 {
+			even++
+		}
+		if c&0x80 != 0 {
+			high++
+		}
+	}
+
+	switch {
+	case high == len(b):
+		return 7, PARITY_MARK
+	case even == len(b) && high > 0:
+		return 7, PARITY_EVEN
+	case even == 0 && high > 0:
+		return 7, PARITY_ODD
+	default:
+		return 8, PARITY_NONE
+	}
+}
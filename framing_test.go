@@ -0,0 +1,59 @@
+package serial
+
+import "testing"
+
+// The framing is guessed from the parity of the bytes and their high
+// bits, and input that is plain ASCII is taken as 8N1.
+func TestGuessFraming(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     []byte
+		bits   int
+		parity Parity
+	}{
+		{"mark", []byte{0xe1, 0xc1}, 7, PARITY_MARK},
+		{"even", []byte{0xe1, 0x41}, 7, PARITY_EVEN},
+		{"odd", []byte{0x61, 0xc1}, 7, PARITY_ODD},
+		{"ascii even", []byte{0x41, 0x42}, 8, PARITY_NONE},
+		{"ascii odd", []byte("abd"), 8, PARITY_NONE},
+		{"ascii", []byte("hello"), 8, PARITY_NONE},
+		{"binary", []byte{0x00, 0x01, 0x80, 0xff}, 8, PARITY_NONE},
+	}
+	for _, tt := range tests {
+		bits, parity := guessFraming(tt.in)
+		if bits != tt.bits || parity != tt.parity {
+			t.Errorf("%s: got %d %v, want %d %v", tt.name, bits, parity, tt.bits, tt.parity)
+		}
+	}
+}
+
+// Parity and framing error marks are removed from the input and
+// counted, including a mark split across reads, and an escaped 0xFF is
+// kept as data.
+func TestDecodeMarks(t *testing.T) {
+	tests := []struct {
+		name string
+		in   [][]byte
+		out  []byte
+		errs int
+	}{
+		{"clean", [][]byte{[]byte("abc")}, []byte("abc"), 0},
+		{"mark", [][]byte{{'a', 0xff, 0x00, 'x', 'b'}}, []byte("ab"), 1},
+		{"escaped", [][]byte{{0xff, 0xff, 'a'}}, []byte{0xff, 'a'}, 0},
+		{"split", [][]byte{{'a', 0xff}, {0x00}, {'x', 'b'}}, []byte("ab"), 1},
+		{"two", [][]byte{{0xff, 0x00, 0x00, 0xff, 0x00, 'y'}}, []byte{}, 2},
+	}
+	for _, tt := range tests {
+		var out []byte
+		state, errs := 0, 0
+		for _, in := range tt.in {
+			b := append([]byte(nil), in...)
+			n, e := decodeMarks(b, &state, false)
+			out = append(out, b[:n]...)
+			errs += e
+		}
+		if string(out) != string(tt.out) || errs != tt.errs {
+			t.Errorf("%s: got %q with %d errors, want %q with %d", tt.name, out, errs, tt.out, tt.errs)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package serial
+
+import (
+	"io"
+	"time"
+)
+
+// Writer that limits its throughput to a fixed byte rate.
+type pacedWriter struct {
+	port *Port
+	rate int
+	next time.Time // earliest time the next chunk may be written
+}
+
+// Create a writer that limits writes to the port to the given number
+// of bytes per second, regardless of the bit rate. Data is written in
+// chunks of about 10ms worth of bytes, each once the time budget of the
+// previous chunk has elapsed; idle time does not build up credit for a
+// later burst. A non-positive rate returns the port itself.
+func (p *Port) NewPacedWriter(bytesPerSec int) io.Writer {
+	if bytesPerSec <= 0 {
+		return p
+	}
+	return &pacedWriter{port: p, rate: bytesPerSec}
+}
+
+func (w *pacedWriter) Write(b []byte) (int, error) {
+	chunk := w.rate / 100
+	if chunk < 1 {
+		chunk = 1
+	}
+
+	n := 0
+	for n < len(b) {
+		if wait := time.Until(w.next); wait > 0 {
+			time.Sleep(wait)
+		}
+		if now := time.Now(); w.next.Before(now) {
+			w.next = now
+		}
+
+		end := n + chunk
+		if end > len(b) {
+			end = len(b)
+		}
+
+		c, err := w.port.Write(b[n:end])
+		n += c
+		w.next = w.next.Add(time.Duration(c) * time.Second / time.Duration(w.rate))
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
@@ -0,0 +1,65 @@
+package serial
+
+import (
+	"sync"
+	"time"
+)
+
+// Serial port pool. A pool owns a single open port and serializes
+// request/response transactions from many goroutines, so that their
+// reads and writes cannot interleave on a half-duplex link.
+type PortPool struct {
+	mu   sync.Mutex
+	port *Port
+}
+
+// Create a pool that owns the given open port.
+func NewPortPool(port *Port) *PortPool {
+	return &PortPool{port: port}
+}
+
+// Get the port owned by the pool.
+func (pp *PortPool) Port() *Port {
+	return pp.port
+}
+
+// Send a command and read the response up to and including the
+// delimiter. Stale input is discarded before the command is sent. The
+// timeout bounds the whole transaction; zero waits forever. On
+// timeout, the partial response is returned with ErrTimeout.
+func (pp *PortPool) Do(cmd []byte, delim byte, timeout time.Duration) ([]byte, error) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	return pp.port.exchange(cmd, []byte{delim}, timeout)
+}
+
+// Discard stale input, send a command and read the response up to and
+// including the delimiter, within timeout; zero waits forever.
+func (p *Port) exchange(cmd, delim []byte, timeout time.Duration) ([]byte, error) {
+	deadline := timeoutDeadline(timeout)
+
+	if err := p.ResetInput(); err != nil {
+		return nil, err
+	}
+	return p.transact(cmd, delim, deadline)
+}
+
+// Send a command and read the response up to and including the
+// delimiter, before the deadline, if any.
+func (p *Port) transact(cmd, delim []byte, deadline time.Time) ([]byte, error) {
+	if len(cmd) > 0 {
+		if _, err := p.write(cmd, deadline); err != nil {
+			return nil, err
+		}
+	}
+
+	return p.readUntil(delim, deadline)
+}
+
+// Close the pool and the port it owns. Waits for any transaction in
+// progress to complete.
+func (pp *PortPool) Close() error {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	return pp.port.Close()
+}
@@ -0,0 +1,81 @@
+package serial
+
+import (
+	"errors"
+	"time"
+)
+
+// How long DTR is dropped to reset an Arduino board on open.
+const arduinoResetPulse = 100 * time.Millisecond
+
+// Option presets for common classes of device, by Options.Profile name.
+var profiles = map[string]Options{
+	// 8N1 without flow control.
+	"raw": RawOptions,
+
+	// 8N1; CR is read as LF and LF is written as CR LF, and the
+	// carrier detect line is ignored.
+	"modem": RawOptions,
+
+	// 4800 8N1, the NMEA 0183 standard.
+	"nmea": {
+		BitRate:     4800,
+		DataBits:    8,
+		Parity:      PARITY_NONE,
+		StopBits:    1,
+		FlowControl: FLOWCONTROL_NONE,
+	},
+
+	// 8N1; DTR is pulsed on open to reset the board, and is left
+	// raised on close so that closing does not reset it again.
+	"arduino": RawOptions,
+}
+
+// Get the options with unset fields filled in from the profile.
+func (o *Options) withProfile() (*Options, error) {
+	if o.Profile == "" {
+		return o, nil
+	}
+
+	base, ok := profiles[o.Profile]
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+
+	options := *o
+	if options.BitRate == 0 {
+		options.BitRate = base.BitRate
+	}
+	if options.DataBits == 0 {
+		options.DataBits = base.DataBits
+	}
+	if options.StopBits == 0 {
+		options.StopBits = base.StopBits
+	}
+	if options.Parity == 0 {
+		options.Parity = base.Parity
+	}
+	if options.FlowControl == 0 {
+		options.FlowControl = base.FlowControl
+	}
+
+	return &options, nil
+}
+
+// Apply the settings of a profile that are not covered by options.
+// Terminal attributes are only set on Linux.
+func (p *Port) applyProfile(profile string) error {
+	if err := p.profileTermios(profile); err != nil && !errors.Is(err, ErrUnsupportedOperation) {
+		return err
+	}
+
+	if profile == "arduino" {
+		if err := p.Apply(&Options{DTR: DTR_OFF}); err != nil {
+			return err
+		}
+		time.Sleep(arduinoResetPulse)
+		return p.Apply(&Options{DTR: DTR_ON})
+	}
+
+	return nil
+}
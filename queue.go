@@ -0,0 +1,133 @@
+package serial
+
+import (
+	"sync"
+)
+
+// Maximum number of writes waiting in the write queue.
+const writeQueueSize = 64
+
+// Queue of writes drained to the port in order by a background
+// goroutine.
+type writeQueue struct {
+	ch      chan []byte
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending int   // writes queued or in progress
+	err     error // first error since the last wait
+	closed  bool  // the port was closed and the queue drained
+}
+
+// Queue data to be written to the port in order by a background
+// goroutine, returning immediately. Queued writes are serialized with
+// other writes to the port and are subject to the write deadline at
+// the time each is written. Returns ErrQueueFull if writeQueueSize
+// writes are already waiting. Use WaitWriteQueue to wait for the
+// queued data to be written.
+func (p *Port) EnqueueWrite(b []byte) error {
+	if p.mode&MODE_WRITE == 0 {
+		return ErrNotWritable
+	} else if !p.isOpen() {
+		return ErrNotOpen
+	} else if len(b) == 0 {
+		return nil
+	}
+
+	p.queueOnce.Do(p.startWriteQueue)
+	q := p.queue
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// checked under the lock, so that nothing is queued after the
+	// queue is drained on close
+	if q.closed {
+		return ErrNotOpen
+	}
+
+	select {
+	case q.ch <- append([]byte(nil), b...):
+		q.pending++
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Wait until the data queued with EnqueueWrite has been written, or
+// the port is closed. Returns the first write error since the last
+// wait, or ErrNotOpen if the port was closed with writes still queued.
+func (p *Port) WaitWriteQueue() error {
+	p.queueOnce.Do(p.startWriteQueue)
+	q := p.queue
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for q.pending > 0 && !q.closed {
+		q.cond.Wait()
+	}
+
+	err := q.err
+	q.err = nil
+	return err
+}
+
+// Start the write queue goroutine.
+func (p *Port) startWriteQueue() {
+	q := &writeQueue{ch: make(chan []byte, writeQueueSize)}
+	q.cond = sync.NewCond(&q.mu)
+	p.queue = q
+
+	if !p.isOpen() {
+		q.closed = true
+		return
+	}
+
+	p.spawn(func(done <-chan struct{}) {
+		for {
+			select {
+			case b := <-q.ch:
+				_, err := p.write(b, p.WriteDeadline())
+				q.done(err)
+			case <-done:
+				q.close()
+				return
+			}
+		}
+	})
+}
+
+// Record the completion of a queued write.
+func (q *writeQueue) done(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.finish(err)
+}
+
+// Close the queue, failing the writes still queued. Later writes are
+// rejected and waits return.
+func (q *writeQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	for {
+		select {
+		case <-q.ch:
+			q.finish(ErrNotOpen)
+		default:
+			q.cond.Broadcast()
+			return
+		}
+	}
+}
+
+// Record the completion of a queued write with the lock held.
+func (q *writeQueue) finish(err error) {
+	if q.err == nil {
+		q.err = err
+	}
+	q.pending--
+	q.cond.Broadcast()
+}
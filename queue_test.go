@@ -0,0 +1,40 @@
+package serial
+
+import (
+	"testing"
+	"time"
+)
+
+// Writes queued after the port is closed are rejected, and waiting on
+// the queue returns, even while the port still reads as open.
+func TestWriteQueueClosed(t *testing.T) {
+	p, err := newPort(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.setOpen(true)
+	p.mode = MODE_READ_WRITE
+	p.done = make(chan struct{})
+
+	p.queueOnce.Do(p.startWriteQueue)
+	p.stopOnce.Do(func() {
+		close(p.done)
+	})
+	p.wg.Wait()
+
+	if err := p.EnqueueWrite([]byte("late")); err != ErrNotOpen {
+		t.Fatalf("EnqueueWrite after close: got %v, want ErrNotOpen", err)
+	}
+
+	waited := make(chan error, 1)
+	go func() {
+		waited <- p.WaitWriteQueue()
+	}()
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatal("WaitWriteQueue did not return after close")
+	}
+
+	p.setOpen(false)
+}
@@ -0,0 +1,291 @@
+package serial
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// Options for reconnecting a ReliablePort.
+type ReconnectOptions struct {
+	MinBackoff time.Duration // delay before the first attempt, default 100ms
+	MaxBackoff time.Duration // upper bound on the doubling delay, default 10s
+	MaxRetries int           // attempts per disconnect; zero retries forever
+}
+
+// Serial port that transparently reopens the underlying port when the
+// device goes away, such as when a USB adapter is unplugged and plugged
+// back in. A system error from a read or write, or the end of file from
+// a read, is taken to mean the device is gone; the port is closed and
+// reopened by name with the same options, waiting between attempts
+// with exponential backoff.
+//
+// Reads and writes during a disconnect wait, within their deadlines,
+// until the port is reopened and are then retried. Data in flight when
+// the device went away is lost. Only the options and deadlines carry
+// over to the reopened port; other settings made on Port() do not.
+// Once the retries are exhausted, the last open error is returned by
+// every call until the ReliablePort is closed.
+type ReliablePort struct {
+	name      string
+	options   Options
+	reconnect ReconnectOptions
+
+	mu            sync.Mutex
+	port          *Port           // nil while reconnecting
+	calls         *sync.WaitGroup // reads and writes in progress on port
+	ready         chan struct{}   // closed when a reconnect completes
+	err           error           // set once reconnecting gives up
+	readDeadline  time.Time
+	writeDeadline time.Time
+	closed        bool
+	done          chan struct{}
+}
+
+// Open a port at the given name that is reopened on disconnect. The
+// first open is not retried.
+func NewReliablePort(name string, options *Options, reconnect *ReconnectOptions) (*ReliablePort, error) {
+	port, err := options.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	rp := &ReliablePort{
+		name:    name,
+		options: *options,
+		port:    port,
+		calls:   new(sync.WaitGroup),
+		done:    make(chan struct{}),
+	}
+	if reconnect != nil {
+		rp.reconnect = *reconnect
+	}
+	if rp.reconnect.MinBackoff <= 0 {
+		rp.reconnect.MinBackoff = 100 * time.Millisecond
+	}
+	if rp.reconnect.MaxBackoff < rp.reconnect.MinBackoff {
+		rp.reconnect.MaxBackoff = 10 * time.Second
+		if rp.reconnect.MaxBackoff < rp.reconnect.MinBackoff {
+			rp.reconnect.MaxBackoff = rp.reconnect.MinBackoff
+		}
+	}
+
+	return rp, nil
+}
+
+// Get the port currently in use. The port changes on reconnect, and is
+// nil while reconnecting.
+func (rp *ReliablePort) Port() *Port {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	return rp.port
+}
+
+// Implementation of io.Reader interface.
+func (rp *ReliablePort) Read(b []byte) (int, error) {
+	for {
+		port, deadline, calls, err := rp.current(true)
+		if err != nil {
+			return 0, err
+		} else if port == nil {
+			if err := rp.wait(nil, deadline); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		n, err := port.read(b, deadline, port.readMode)
+		calls.Done()
+		if !errors.Is(err, ErrSystem) && err != io.EOF {
+			return n, err
+		}
+		if err := rp.wait(port, deadline); err != nil {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// Implementation of io.Writer interface. Bytes written before a
+// disconnect are not written again.
+func (rp *ReliablePort) Write(b []byte) (int, error) {
+	n := 0
+	for {
+		port, deadline, calls, err := rp.current(false)
+		if err != nil {
+			return n, err
+		} else if port == nil {
+			if err := rp.wait(nil, deadline); err != nil {
+				return n, err
+			}
+			continue
+		}
+		c, err := port.write(b[n:], deadline)
+		calls.Done()
+		n += c
+		if !errors.Is(err, ErrSystem) {
+			return n, err
+		}
+		if err := rp.wait(port, deadline); err != nil {
+			return n, err
+		}
+	}
+}
+
+// Get the port and deadline to use for a read or write. The port is nil
+// while reconnecting; otherwise the call is counted in the returned
+// wait group, which the caller must mark done once the call returns.
+func (rp *ReliablePort) current(read bool) (*Port, time.Time, *sync.WaitGroup, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	if rp.closed {
+		return nil, time.Time{}, nil, ErrNotOpen
+	} else if rp.err != nil {
+		return nil, time.Time{}, nil, rp.err
+	}
+
+	deadline := rp.writeDeadline
+	if read {
+		deadline = rp.readDeadline
+	}
+
+	if rp.port != nil {
+		rp.calls.Add(1)
+	}
+	return rp.port, deadline, rp.calls, nil
+}
+
+// Wait for the port that failed to be replaced, starting a reconnect if
+// one is not already in progress, or for the reconnect in progress if
+// failed is nil. The failed port is taken out of use at once, so that
+// no further calls are made on it.
+func (rp *ReliablePort) wait(failed *Port, deadline time.Time) error {
+	rp.mu.Lock()
+	if rp.closed {
+		rp.mu.Unlock()
+		return ErrNotOpen
+	}
+	if failed != nil && rp.port == failed && rp.ready == nil {
+		rp.ready = make(chan struct{})
+		go rp.reopen(failed, rp.calls, rp.ready)
+		rp.port, rp.calls = nil, new(sync.WaitGroup)
+	}
+	ready := rp.ready
+	rp.mu.Unlock()
+
+	if ready == nil {
+		// already reconnected
+		return nil
+	}
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-ready:
+		rp.mu.Lock()
+		defer rp.mu.Unlock()
+		if rp.closed {
+			return ErrNotOpen
+		}
+		return rp.err
+	case <-timeout:
+		return ErrTimeout
+	case <-rp.done:
+		return ErrNotOpen
+	}
+}
+
+// Close the failed port once the calls in progress on it have returned,
+// and reopen it with backoff.
+func (rp *ReliablePort) reopen(failed *Port, calls *sync.WaitGroup, ready chan struct{}) {
+	calls.Wait()
+	failed.Close()
+
+	var port *Port
+	var err error
+	backoff := rp.reconnect.MinBackoff
+	for attempt := 0; rp.reconnect.MaxRetries == 0 || attempt < rp.reconnect.MaxRetries; attempt++ {
+		select {
+		case <-time.After(backoff):
+		case <-rp.done:
+			return
+		}
+
+		if port, err = rp.options.Open(rp.name); err == nil {
+			break
+		}
+
+		if backoff *= 2; backoff > rp.reconnect.MaxBackoff {
+			backoff = rp.reconnect.MaxBackoff
+		}
+	}
+
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	if rp.closed {
+		if port != nil {
+			port.Close()
+		}
+		return
+	}
+
+	if err != nil {
+		rp.err = err
+	} else {
+		rp.port = port
+	}
+	rp.ready = nil
+	close(ready)
+}
+
+// Set the read and write deadlines.
+func (rp *ReliablePort) SetDeadline(t time.Time) error {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	rp.readDeadline = t
+	rp.writeDeadline = t
+	return nil
+}
+
+// Set the read deadline.
+func (rp *ReliablePort) SetReadDeadline(t time.Time) error {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	rp.readDeadline = t
+	return nil
+}
+
+// Set the write deadline.
+func (rp *ReliablePort) SetWriteDeadline(t time.Time) error {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	rp.writeDeadline = t
+	return nil
+}
+
+// Close the port and stop reconnecting. Later calls return nil.
+func (rp *ReliablePort) Close() error {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	if rp.closed {
+		return nil
+	}
+	rp.closed = true
+	close(rp.done)
+
+	if rp.port == nil {
+		// the failed port is closed by the reconnect
+		return nil
+	}
+	return rp.port.Close()
+}
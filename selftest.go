@@ -0,0 +1,83 @@
+package serial
+
+import (
+	"fmt"
+	"time"
+)
+
+// Check the port with a loopback jumper connecting TX to RX. Every
+// byte value is written and read back within timeout, and the data
+// read must match the data written; a timeout of zero waits forever.
+// Pending input is discarded first. Software flow control must be
+// disabled, as XON and XOFF would be consumed by the driver. Echo
+// cancellation is suspended for the test.
+//
+// On failure, the error describes the first mismatched byte, or how
+// many bytes arrived before the timeout; a timeout error reports true
+// from Timeout().
+func (p *Port) SelfTest(timeout time.Duration) error {
+	pattern := make([]byte, 256)
+	for i := range pattern {
+		pattern[i] = byte(i)
+	}
+
+	p.mu.Lock()
+	echoCancel := p.echoCancel
+	p.echoCancel = false
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.echoCancel = echoCancel
+		p.mu.Unlock()
+	}()
+
+	if err := p.ResetInput(); err != nil {
+		return err
+	}
+
+	deadline := timeoutDeadline(timeout)
+	if _, err := p.write(pattern, deadline); err != nil {
+		return err
+	}
+
+	buf := make([]byte, len(pattern))
+	n := 0
+	for n < len(buf) {
+		c, err := p.read(buf[n:], deadline, READ_FIRST_BYTE)
+		n += c
+		if err == ErrTimeout {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		if buf[i] != pattern[i] {
+			return &Error{msg: fmt.Sprintf("Self test mismatch at byte %d: sent 0x%02X, received 0x%02X", i, pattern[i], buf[i])}
+		}
+	}
+	if n < len(pattern) {
+		return &Error{msg: fmt.Sprintf("Self test timed out after receiving %d of %d bytes", n, len(pattern)), timeout: true}
+	}
+
+	return nil
+}
+
+// Measure the round trip time to the device: pending input is
+// discarded, the probe is sent, and the response is read up to and
+// including the delimiter, within timeout; zero waits forever. Only
+// the exchange is timed, not the discarding of input. Use it to
+// compare latency settings such as the FTDI latency timer.
+func (p *Port) Ping(probe []byte, delim byte, timeout time.Duration) (time.Duration, error) {
+	if err := p.ResetInput(); err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	deadline := timeoutDeadline(timeout)
+	if _, err := p.transact(probe, []byte{delim}, deadline); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
@@ -4,6 +4,12 @@ Package serial provides a binding to libserialport for serial port
 functionality. Serial ports are commonly used with embedded systems,
 such as the Arduino platform.
 
+All reads and writes go directly through the libserialport blocking
+and nonblocking I/O calls; ports are not wrapped in an os.File and do
+not use the Go runtime network poller. Reads and writes without a
+deadline block until complete, unless a default timeout is set with
+SetDefaultTimeout.
+
 Example Usage
 
 	package main
@@ -36,6 +42,8 @@ package serial
 
 /*
 #cgo CFLAGS: -g -O2 -Wall -Wextra -DSP_PRIV= -DSP_API=
+#cgo linux,!ppc64,!ppc64le CFLAGS: -DHAVE_TERMIOS2 -DHAVE_TERMIOS2_SPEED -DHAVE_BOTHER
+#cgo linux,ppc64 linux,ppc64le CFLAGS: -DHAVE_TERMIOS_SPEED -DHAVE_BOTHER
 #cgo darwin LDFLAGS: -framework IOKit -framework CoreFoundation
 
 #include <stdarg.h>
@@ -59,17 +67,29 @@ import "C"
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net"
-	"reflect"
+	"os"
 	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode/utf8"
 	"unsafe"
 )
 
 // Debug flag
 const Debug = false
 
+// Maximum number of written bytes remembered for echo cancellation.
+const echoLimit = 4096
+
 // Port access modes
 const (
 	MODE_READ       = C.SP_MODE_READ       // Open port for read access
@@ -84,62 +104,83 @@ const (
 	EVENT_ERROR    = C.SP_EVENT_ERROR    // Error occured.
 )
 
+// Parity setting.
+type Parity int
+
 // Parity settings.
 const (
-	PARITY_INVALID = iota // Special value to indicate setting should be left alone.
-	PARITY_NONE           // No parity.
-	PARITY_ODD            // Odd parity.
-	PARITY_EVEN           // Even parity.
-	PARITY_MARK           // Mark parity.
-	PARITY_SPACE          // Space parity.
+	PARITY_INVALID Parity = iota // Special value to indicate setting should be left alone.
+	PARITY_NONE                  // No parity.
+	PARITY_ODD                   // Odd parity.
+	PARITY_EVEN                  // Even parity.
+	PARITY_MARK                  // Mark parity.
+	PARITY_SPACE                 // Space parity.
 )
 
+// RTS pin behaviour.
+type RTSMode int
+
 // RTS pin behaviour.
 const (
-	RTS_INVALID      = iota // Special value to indicate setting should be left alone.
-	RTS_OFF                 // RTS off.
-	RTS_ON                  // RTS on.
-	RTS_FLOW_CONTROL        // RTS used for flow control.
+	RTS_INVALID      RTSMode = iota // Special value to indicate setting should be left alone.
+	RTS_OFF                         // RTS off.
+	RTS_ON                          // RTS on.
+	RTS_FLOW_CONTROL                // RTS used for flow control.
 )
 
+// CTS pin behaviour.
+type CTSMode int
+
 // CTS pin behaviour.
 const (
-	CTS_INVALID      = iota // Special value to indicate setting should be left alone.
-	CTS_IGNORE              // CTS ignored.
-	CTS_FLOW_CONTROL        // CTS used for flow control.
+	CTS_INVALID      CTSMode = iota // Special value to indicate setting should be left alone.
+	CTS_IGNORE                      // CTS ignored.
+	CTS_FLOW_CONTROL                // CTS used for flow control.
 )
 
+// DTR pin behaviour.
+type DTRMode int
+
 // DTR pin behaviour.
 const (
-	DTR_INVALID      = iota // Special value to indicate setting should be left alone.
-	DTR_OFF                 // DTR off.
-	DTR_ON                  // DTR on.
-	DTR_FLOW_CONTROL        // DTR used for flow control.
+	DTR_INVALID      DTRMode = iota // Special value to indicate setting should be left alone.
+	DTR_OFF                         // DTR off.
+	DTR_ON                          // DTR on.
+	DTR_FLOW_CONTROL                // DTR used for flow control.
 )
 
+// DSR pin behaviour.
+type DSRMode int
+
 // DSR pin behaviour.
 const (
-	DSR_INVALID      = iota // Special value to indicate setting should be left alone.
-	DSR_IGNORE              // DSR ignored.
-	DSR_FLOW_CONTROL        // DSR used for flow control.
+	DSR_INVALID      DSRMode = iota // Special value to indicate setting should be left alone.
+	DSR_IGNORE                      // DSR ignored.
+	DSR_FLOW_CONTROL                // DSR used for flow control.
 )
 
+// XON/XOFF flow control behaviour.
+type XonXoffMode int
+
 // XON/XOFF flow control behaviour.
 const (
-	XONXOFF_INVALID  = iota // Special value to indicate setting should be left alone.
-	XONXOFF_DISABLED        // XON/XOFF disabled.
-	XONXOFF_IN              // XON/XOFF enabled for input only.
-	XONXOFF_OUT             // XON/XOFF enabled for output only.
-	XONXOFF_INOUT           // XON/XOFF enabled for input and output.
+	XONXOFF_INVALID  XonXoffMode = iota // Special value to indicate setting should be left alone.
+	XONXOFF_DISABLED                    // XON/XOFF disabled.
+	XONXOFF_IN                          // XON/XOFF enabled for input only.
+	XONXOFF_OUT                         // XON/XOFF enabled for output only.
+	XONXOFF_INOUT                       // XON/XOFF enabled for input and output.
 )
 
+// Flow control combination.
+type FlowControl int
+
 // Standard flow control combinations.
 const (
-	_                   = iota
-	FLOWCONTROL_NONE    // No flow control.
-	FLOWCONTROL_XONXOFF // Software flow control using XON/XOFF characters.
-	FLOWCONTROL_RTSCTS  // Hardware flow control using RTS/CTS signals.
-	FLOWCONTROL_DTRDSR  // Hardware flow control using DTR/DSR signals.
+	FLOWCONTROL_INVALID FlowControl = iota // Special value to indicate setting should be left alone.
+	FLOWCONTROL_NONE                       // No flow control.
+	FLOWCONTROL_XONXOFF                    // Software flow control using XON/XOFF characters.
+	FLOWCONTROL_RTSCTS                     // Hardware flow control using RTS/CTS signals.
+	FLOWCONTROL_DTRDSR                     // Hardware flow control using DTR/DSR signals.
 )
 
 // Input signals
@@ -150,41 +191,153 @@ const (
 	SIG_RI  = C.SP_SIG_RI  // Ring indicator
 )
 
+// Output modem control lines.
+const (
+	lineDTR = 1 << iota
+	lineRTS
+)
+
+// Transport type.
+type Transport int
+
 // Transport types.
 const (
-	TRANSPORT_NATIVE    = C.SP_TRANSPORT_NATIVE    // Native platform serial port.
-	TRANSPORT_USB       = C.SP_TRANSPORT_USB       // USB serial port adapter.
-	TRANSPORT_BLUETOOTH = C.SP_TRANSPORT_BLUETOOTH // Bluetooh serial port adapter.
+	TRANSPORT_NATIVE    Transport = C.SP_TRANSPORT_NATIVE    // Native platform serial port.
+	TRANSPORT_USB       Transport = C.SP_TRANSPORT_USB       // USB serial port adapter.
+	TRANSPORT_BLUETOOTH Transport = C.SP_TRANSPORT_BLUETOOTH // Bluetooh serial port adapter.
+)
+
+// Received break handling.
+const (
+	BREAK_INVALID = iota // Special value to indicate setting should be left alone.
+	BREAK_NUL            // Read a break as a NUL byte.
+	BREAK_IGNORE         // Discard breaks.
+	BREAK_MARK           // Read a break as the bytes 0xFF 0x00 0x00.
+)
+
+// Linux line disciplines.
+const (
+	LDISC_TTY  = 0  // Standard terminal line discipline (N_TTY).
+	LDISC_SLIP = 1  // Serial Line IP (N_SLIP).
+	LDISC_PPP  = 3  // Point-to-Point Protocol (N_PPP).
+	LDISC_PPS  = 18 // Pulse per second from the DCD line (N_PPS).
+)
+
+// Read modes.
+const (
+	READ_FILL_BUFFER = iota // Block until the buffer is full or the deadline expires.
+	READ_FIRST_BYTE         // Return as soon as any data is available.
 )
 
 // Serial port info.
 type Info struct {
 	p      *C.struct_sp_port
-	opened bool
+	opened int32 // accessed atomically
 }
 
 // Serial port options.
 type Options struct {
-	Mode        int // read, write; default is read
-	BitRate     int // number of bits per second (baudrate)
-	DataBits    int // number of data bits (5, 6, 7, 8)
-	StopBits    int // number of stop bits (1, 2)
-	Parity      int // none, odd, even, mark, space
-	FlowControl int // none, xonxoff, rtscts, dtrdsr
-
-	RTS int
-	CTS int
-	DTR int
-	DSR int
+	Mode        int         // read, write; default is read
+	BitRate     int         // number of bits per second (baudrate)
+	DataBits    int         // number of data bits (5, 6, 7, 8)
+	StopBits    int         // number of stop bits (1, 2)
+	Parity      Parity      // none, odd, even, mark, space
+	FlowControl FlowControl // none, xonxoff, rtscts, dtrdsr
+
+	RTS RTSMode
+	CTS CTSMode
+	DTR DTRMode
+	DSR DSRMode
+
+	// Name of a preset for a class of device, which fills in the
+	// options left unset and applies device-specific settings on open:
+	// "raw", "modem", "nmea" or "arduino". Opening with an unknown
+	// profile returns ErrInvalidArguments.
+	Profile string
+
+	// Open for reading only, without applying any other options, to
+	// monitor a link that another program has configured. Where
+	// supported, the terminal attributes that libserialport changes on
+	// open are restored, so that the port is left exactly as it was
+	// found; only Linux is currently supported. The modem control lines
+	// are not changed, although the system may raise DTR and RTS if no
+	// other program has the port open.
+	Passive bool
+
+	// Fail reads with ErrParity when a parity or framing error is
+	// received, rather than passing the byte through. See
+	// SetAbortOnParityError.
+	AbortOnParityError bool
+
+	// Keep data already in the kernel buffers when the port is opened,
+	// such as a startup banner received before the program started.
+	// Opening never discards buffered data on any platform, so this is
+	// also the default; set it to record that the program relies on
+	// it. Call ResetInput after opening to discard stale input instead.
+	PreserveBuffers bool
+
+	// Called after the port is opened and configured, before it is
+	// returned, for device-specific initialization such as sending a
+	// wake-up sequence. If it returns an error, the port is closed and
+	// the error is returned from the open.
+	OnOpen func(*Port) error `json:"-"`
+
+	// Expected size of reads, used to size the buffer that line and
+	// character reads fill ahead of the data they return. Larger
+	// values reduce the number of calls into the driver at high data
+	// rates. The kernel buffers are not affected.
+	ReadBufferHint int
 }
 
 // Serial port.
+//
+// One goroutine may read from a port while others write to it, query
+// its buffers and signals, change its configuration and settings and
+// set its deadlines. Writes are serialized, as are configuration
+// changes that read and then modify the port configuration, such as
+// ApplyAtomic. Reads must be made from one goroutine at a time, as they
+// share the buffered input. Reads and writes are made in calls of at
+// most 100ms into libserialport, and configuration changes and Close
+// wait for the calls in progress rather than racing them.
 type Port struct {
 	Info
 	c             *C.struct_sp_port_config
 	readDeadline  time.Time
 	writeDeadline time.Time
-}
+	readMode      int
+	mode          int
+	rbuf          []byte
+	bufSize       int
+	timeout       time.Duration
+	readInterval  time.Duration
+	policy        bool
+	vmin          int
+	vtime         int
+	parityAbort   bool
+	markState     int
+	adaptive      *adaptiveBaud
+	capture       capture
+	lastRead      time.Time
+	echoCancel    bool
+	echo          []byte
+	lineTerm      []byte
+	maxRead       int
+	txDelay       time.Duration
+	mu            sync.Mutex   // guards c, the deadlines and the read and echo state
+	cmu           sync.Mutex   // serializes configuration changes
+	wmu           sync.Mutex   // serializes writes
+	iomu          sync.RWMutex // held by reads and writes, exclusively to reconfigure or close
+	bmu           sync.Mutex   // serializes timed breaks
+	queue         *writeQueue
+	queueOnce     sync.Once
+	done          chan struct{}
+	wg            sync.WaitGroup
+	callbacks     int32     // callbacks running, accessed atomically
+	stopOnce      sync.Once // closes done
+}
+
+// Check that Port implements net.Conn.
+var _ net.Conn = (*Port)(nil)
 
 // Implementation of net.Addr
 type Addr struct {
@@ -198,6 +351,41 @@ type Error struct {
 	temporary bool
 }
 
+// Error returned when a bit rate is not supported by the system or
+// device, so that the caller can offer another rate. It wraps
+// ErrUnsupportedOperation.
+type UnsupportedBitRateError struct {
+	BitRate int // the bit rate that was attempted
+	Actual  int // the bit rate read back, or zero if the rate was refused
+}
+
+// Error returned when a configuration change fails and the previous
+// configuration cannot be restored either, so that the port may be
+// left partially reconfigured. It wraps the error from the change.
+type RollbackError struct {
+	Err      error // the error from the configuration change
+	Rollback error // the error restoring the previous configuration
+}
+
+// Error returned when a libserialport operation fails, naming the
+// operation. Err is one of the package errors, such as ErrSystem or
+// ErrUnsupportedOperation, or an *OSError for system failures, so use
+// errors.Is or errors.As to inspect it. Timeouts, the end of file and
+// errors detected by this package are not wrapped.
+type OpError struct {
+	Op   string // libserialport operation, such as "open" or "set_config"
+	Code int    // libserialport return code, one of the SP_ERR_* values
+	Err  error
+}
+
+// Error returned when a system call fails, carrying the operating
+// system error code and message, such as for permission denied or a
+// device in use. It wraps ErrSystem.
+type OSError struct {
+	Code    int    // errno, or the Windows error code
+	Message string // description of the error from the system
+}
+
 var RawOptions = Options{
 	DataBits:    8,
 	Parity:      PARITY_NONE,
@@ -209,21 +397,108 @@ var ErrInvalidArguments = &Error{msg: "Invalid arguments were passed to the func
 var ErrSystem = &Error{msg: "A system error occured while executing the operation"}
 var ErrMemoryAllocation = &Error{msg: "A memory allocation failed while executing the operation"}
 var ErrUnsupportedOperation = &Error{msg: "The requested operation is not supported by this system or device"}
-var ErrTimeout = &Error{msg: "Operation timed out", timeout: true}
+var ErrTimeout = &Error{msg: "Operation timed out", timeout: true, temporary: true}
+var ErrAlreadyOpen = &Error{msg: "The port is already open"}
+var ErrNotReadable = &Error{msg: "The port was not opened for reading"}
+var ErrNotWritable = &Error{msg: "The port was not opened for writing"}
+var ErrNotDrained = &Error{msg: "The output buffer was not fully drained"}
+var ErrNotOpen = &Error{msg: "The port is not open"}
+var ErrNoPort = &Error{msg: "No serial port was found"}
+var ErrAmbiguousPort = &Error{msg: "More than one serial port was found"}
+var ErrQueueFull = &Error{msg: "The write queue is full", temporary: true}
+var ErrTooLong = &Error{msg: "The data read exceeded the maximum read size"}
+var ErrATCommand = &Error{msg: "The modem returned an error result"}
+var ErrParity = &Error{msg: "A parity or framing error was received"}
+var ErrUnknownFraming = &Error{msg: "The data bits or parity of the port are not known"}
+
+// Map an error setting the given bit rate to UnsupportedBitRateError.
+// The bit rate must be the only setting that may be unsupported.
+func bitRateErr(err error, bitrate int) error {
+	if errors.Is(err, ErrUnsupportedOperation) {
+		return &UnsupportedBitRateError{BitRate: bitrate}
+	}
+	return err
+}
+
+// Check whether options other than the bit rate may be unsupported:
+// mark and space parity, DTR/DSR flow control and mismatched RTS/CTS
+// flow control.
+func (o *Options) mayBeUnsupported() bool {
+	return o.Parity == PARITY_MARK || o.Parity == PARITY_SPACE ||
+		o.FlowControl == FLOWCONTROL_DTRDSR ||
+		o.RTS != 0 || o.CTS != 0 || o.DTR != 0 || o.DSR != 0
+}
+
+// Check that the bit rate read back from the port is within 2% of the
+// one set, as the driver may pick the nearest divisor it can and a UART
+// tolerates that much error. Otherwise the previous bit rate is
+// restored, so that the port is not left at a rate that was not asked
+// for. Must be called with cmu held.
+func (p *Port) checkBitRate(bitrate, previous int) error {
+	actual, err := p.BitRate()
+	if err != nil {
+		return err
+	}
+	diff := actual - bitrate
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff*100 <= bitrate*2 {
+		return nil
+	}
 
-// Map error codes to errors.
-func errmsg(err C.enum_sp_return) error {
-	switch err {
+	p.iomu.Lock()
+	ret, errno := C.sp_set_baudrate(p.p, C.int(previous))
+	p.iomu.Unlock()
+	if err := errmsg("set_baudrate", ret, errno); err != nil {
+		return err
+	}
+	if err := p.getConf(); err != nil {
+		return err
+	}
+	return &UnsupportedBitRateError{BitRate: bitrate, Actual: actual}
+}
+
+// Map error codes to errors, wrapped in an OpError naming the
+// libserialport operation that failed. The errno must be captured by
+// the call that failed, with the two-value cgo form, as it is only
+// valid on the thread that made the call.
+func errmsg(op string, ret C.enum_sp_return, errno error) error {
+	var err error
+	switch ret {
 	case C.SP_ERR_ARG:
-		return ErrInvalidArguments
+		err = ErrInvalidArguments
 	case C.SP_ERR_FAIL:
-		return ErrSystem
+		err = osError(errno)
 	case C.SP_ERR_MEM:
-		return ErrMemoryAllocation
+		err = ErrMemoryAllocation
 	case C.SP_ERR_SUPP:
-		return ErrUnsupportedOperation
+		err = ErrUnsupportedOperation
+	default:
+		return nil
 	}
-	return nil
+	return &OpError{Op: op, Code: int(ret), Err: err}
+}
+
+// Get the error code and message of the last operating system error
+// reported by libserialport, such as EACCES for permission denied or
+// EBUSY for a device in use. The error is that of the calling thread,
+// so call it immediately after the failed operation with the goroutine
+// locked to its thread by runtime.LockOSThread. Errors returned by
+// this package for system failures already carry the code and message
+// in an *OSError.
+func LastError() (int, string) {
+	cmsg := C.sp_last_error_message()
+	defer C.sp_free_error_message(cmsg)
+	return int(C.sp_last_error_code()), C.GoString(cmsg)
+}
+
+// Wrap a system call error in an OSError.
+func osError(err error) error {
+	if e, ok := err.(syscall.Errno); ok && e != 0 {
+		return &OSError{Code: int(e), Message: e.Error()}
+	}
+	return ErrSystem
 }
 
 // Wrap a sp_port struct in a go Port struct and set finalizer for
@@ -237,15 +512,30 @@ func newInfo(p *C.struct_sp_port) (*Info, error) {
 // Finalizer callback for garbage collection.
 func (i *Info) free() {
 	if i.p != nil {
-		if i.opened {
+		if i.isOpen() {
 			C.sp_close(i.p)
+			atomic.AddInt64(&openPorts, -1)
 		}
 		C.sp_free_port(i.p)
 	}
-	i.opened = false
+	i.setOpen(false)
 	i.p = nil
 }
 
+// Check whether the port is open.
+func (i *Info) isOpen() bool {
+	return atomic.LoadInt32(&i.opened) != 0
+}
+
+// Set whether the port is open.
+func (i *Info) setOpen(open bool) {
+	var v int32
+	if open {
+		v = 1
+	}
+	atomic.StoreInt32(&i.opened, v)
+}
+
 // Wrap a sp_port struct in a go Port struct and set finalizer for
 // garbage collection.
 func newPort(info *Info) (*Port, error) {
@@ -253,7 +543,8 @@ func newPort(info *Info) (*Port, error) {
 
 	// copy info
 	if info != nil {
-		if err := errmsg(C.sp_copy_port(info.p, &port.p)); err != nil {
+		ret, errno := C.sp_copy_port(info.p, &port.p)
+		if err := errmsg("copy_port", ret, errno); err != nil {
 			return nil, err
 		}
 	}
@@ -264,7 +555,8 @@ func newPort(info *Info) (*Port, error) {
 	return port, nil
 }
 
-// Finalizer callback for garbage collection.
+// Finalizer callback for garbage collection. A port that was closed is
+// not closed again.
 func (p *Port) free() {
 	p.Info.free()
 	if p.c != nil {
@@ -277,11 +569,11 @@ func (p *Port) free() {
 func deadline2millis(deadline time.Time) int64 {
 	delta := deadline.Sub(time.Now())
 
-	duration := time.Duration(delta.Nanoseconds())
-	duration += duration + time.Millisecond - time.Nanosecond
-	duration /= time.Millisecond
-
-	millis := int64(duration)
+	// round up so that a sub-millisecond deadline still blocks
+	millis := int64(0)
+	if delta > 0 {
+		millis = int64((delta + time.Millisecond - time.Nanosecond) / time.Millisecond)
+	}
 
 	if Debug {
 		log.Printf("timeout: %d ns %d ms", delta, millis)
@@ -290,6 +582,51 @@ func deadline2millis(deadline time.Time) int64 {
 	return millis
 }
 
+// Longest time a read or write waits in a single call into
+// libserialport. Close and configuration changes wait for the call in
+// progress, so this bounds how long they are held off.
+const ioSlice = 100 * time.Millisecond
+
+// Get the timeout in milliseconds for the next call of a read or write
+// with the deadline, at most ioSlice, or zero for a nonblocking call
+// once the deadline has expired.
+func ioMillis(deadline time.Time) int64 {
+	millis := int64(ioSlice / time.Millisecond)
+	if !deadline.IsZero() {
+		if m := deadline2millis(deadline); m < millis {
+			millis = m
+		}
+	}
+	return millis
+}
+
+// Get the deadline for a timeout starting now. A timeout of zero or
+// less gives the zero time, which, as for SetDeadline, means no
+// deadline.
+func timeoutDeadline(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
+
+// Check whether a deadline, if any, has expired.
+func expired(deadline time.Time) bool {
+	return !deadline.IsZero() && !time.Now().Before(deadline)
+}
+
+// Hold off Close and configuration changes during a call into
+// libserialport that reads or writes, until iomu is released. Returns
+// ErrNotOpen if the port is closed or being closed.
+func (p *Port) beginIO() error {
+	p.iomu.RLock()
+	if !p.isOpen() {
+		p.iomu.RUnlock()
+		return ErrNotOpen
+	}
+	return nil
+}
+
 // Print libserialport debug messages to stderr.
 func SetDebug(enable bool) {
 	if enable {
@@ -313,36 +650,44 @@ func portByName(name string) (*C.struct_sp_port, error) {
 	cname := C.CString(name)
 	defer C.free(unsafe.Pointer(cname))
 
-	if err := errmsg(C.sp_get_port_by_name(cname, &p)); err != nil {
+	ret, errno := C.sp_get_port_by_name(cname, &p)
+	if err := errmsg("get_port_by_name", ret, errno); err != nil {
 		return nil, err
 	}
 
 	return p, nil
 }
 
+// Get the version of libserialport the package is built with, for bug
+// reports and to check for features added in later releases.
+func LibraryVersion() (major, minor, micro int, str string) {
+	major = int(C.sp_get_major_package_version())
+	minor = int(C.sp_get_minor_package_version())
+	micro = int(C.sp_get_micro_package_version())
+	str = C.GoString(C.sp_get_package_version_string())
+	return
+}
+
 // List the serial ports available on the system.
 func ListPorts() ([]*Info, error) {
 	var p **C.struct_sp_port
 
-	if err := C.sp_list_ports(&p); err != C.SP_OK {
-		return nil, errmsg(err)
+	if ret, errno := C.sp_list_ports(&p); ret != C.SP_OK {
+		return nil, errmsg("list_ports", ret, errno)
 	}
 	defer C.sp_free_port_list(p)
 
 	// Convert the C array into a Go slice
 	// See: https://code.google.com/p/go-wiki/wiki/cgo
 	pp := (*[1 << 15]*C.struct_sp_port)(unsafe.Pointer(p))
-
-	// count number of ports
-	c := 0
-	for ; uintptr(unsafe.Pointer(pp[c])) != 0; c++ {
-	}
+	c := countPorts(pp)
 
 	// populate
 	ports := make([]*Info, c)
 	for j := 0; j < c; j++ {
 		var pc *C.struct_sp_port
-		if err := errmsg(C.sp_copy_port(pp[j], &pc)); err != nil {
+		ret, errno := C.sp_copy_port(pp[j], &pc)
+		if err := errmsg("copy_port", ret, errno); err != nil {
 			return nil, err
 		}
 		if sp, err := newInfo(pc); err != nil {
@@ -355,26 +700,107 @@ func ListPorts() ([]*Info, error) {
 	return ports, nil
 }
 
+// Count the serial ports available on the system, without copying
+// them as ListPorts does.
+func CountPorts() (int, error) {
+	var p **C.struct_sp_port
+
+	if ret, errno := C.sp_list_ports(&p); ret != C.SP_OK {
+		return 0, errmsg("list_ports", ret, errno)
+	}
+	defer C.sp_free_port_list(p)
+
+	return countPorts((*[1 << 15]*C.struct_sp_port)(unsafe.Pointer(p))), nil
+}
+
+// Count the ports in a NULL-terminated port list.
+func countPorts(pp *[1 << 15]*C.struct_sp_port) int {
+	c := 0
+	for ; uintptr(unsafe.Pointer(pp[c])) != 0; c++ {
+	}
+	return c
+}
+
+// List the serial ports that are likely backed by real hardware. USB
+// and Bluetooth adapters are always included. On Linux, the legacy
+// ttyS ports the serial8250 driver registers without a UART behind them
+// are left out.
+func ListRealPorts() ([]*Info, error) {
+	ports, err := ListPorts()
+	if err != nil {
+		return nil, err
+	}
+
+	real := ports[:0]
+	for _, info := range ports {
+		if info.Transport() != TRANSPORT_NATIVE || isNativePortPresent(info.Name()) {
+			real = append(real, info)
+		}
+	}
+
+	return real, nil
+}
+
+// Cached port enumeration for ListPortsCached.
+var portCache struct {
+	sync.Mutex
+	ports []*Info
+	time  time.Time
+}
+
+// List the serial ports available on the system, reusing the result
+// of an earlier call if it is no older than maxAge. This avoids
+// enumerating the ports on every call when polling the port list. The
+// ports are shared between callers and should not be modified.
+func ListPortsCached(maxAge time.Duration) ([]*Info, error) {
+	portCache.Lock()
+	defer portCache.Unlock()
+
+	if portCache.ports == nil || time.Since(portCache.time) > maxAge {
+		ports, err := ListPorts()
+		if err != nil {
+			return nil, err
+		}
+		portCache.ports, portCache.time = ports, time.Now()
+	}
+
+	return append([]*Info(nil), portCache.ports...), nil
+}
+
 // Get the name of a port.
 func (i *Info) Name() string {
 	return C.GoString(C.sp_get_port_name(i.p))
 }
 
+// Get the name of a port in a form that is consistent across
+// platforms, for use in configuration shared between systems. The
+// "/dev/" prefix is removed on Unix systems; on Windows, the "\\.\"
+// prefix is removed and the name is upper-cased, so that "/dev/ttyUSB0"
+// becomes "ttyUSB0" and "\\.\com3" becomes "COM3".
+func (i *Info) CanonicalName() string {
+	name := i.Name()
+	if runtime.GOOS == "windows" {
+		return strings.ToUpper(strings.TrimPrefix(name, `\\.\`))
+	}
+	return strings.TrimPrefix(name, "/dev/")
+}
+
 // Get a description for a port, to present to end user.
 func (i *Info) Description() string {
 	return C.GoString(C.sp_get_port_description(i.p))
 }
 
 // Get the transport type used by a port.
-func (i *Info) Transport() int {
+func (i *Info) Transport() Transport {
 	t := C.sp_get_port_transport(i.p)
-	return int(t)
+	return Transport(t)
 }
 
 // Get the USB bus number and address on bus of a USB serial adapter port.
 func (i *Info) USBBusAddress() (int, int, error) {
 	var bus, address C.int
-	if err := errmsg(C.sp_get_port_usb_bus_address(i.p, &bus, &address)); err != nil {
+	ret, errno := C.sp_get_port_usb_bus_address(i.p, &bus, &address)
+	if err := errmsg("get_port_usb_bus_address", ret, errno); err != nil {
 		return 0, 0, err
 	}
 	return int(bus), int(address), nil
@@ -383,7 +809,8 @@ func (i *Info) USBBusAddress() (int, int, error) {
 // Get the USB Vendor ID and Product ID of a USB serial adapter port.
 func (i *Info) USBVIDPID() (int, int, error) {
 	var vid, pid C.int
-	if err := errmsg(C.sp_get_port_usb_vid_pid(i.p, &vid, &pid)); err != nil {
+	ret, errno := C.sp_get_port_usb_vid_pid(i.p, &vid, &pid)
+	if err := errmsg("get_port_usb_vid_pid", ret, errno); err != nil {
 		return 0, 0, err
 	}
 	return int(vid), int(pid), nil
@@ -407,6 +834,35 @@ func (i *Info) USBSerialNumber() string {
 	return C.GoString(cdesc)
 }
 
+// Get the name of the kernel driver for a port, such as "ftdi_sio",
+// "cp210x" or "ch341" on Linux. Returns ErrUnsupportedOperation where
+// the driver cannot be determined; only Linux is currently supported.
+func (i *Info) DriverName() (string, error) {
+	return driverName(i.Name())
+}
+
+// USB serial adapter chip families by vendor ID.
+var chipFamilies = map[int]string{
+	0x0403: "FTDI",
+	0x067B: "PL2303",
+	0x10C4: "CP210x",
+	0x1A86: "CH34x",
+}
+
+// Get the chip family of a USB serial adapter port, derived from its
+// vendor ID. Returns ErrUnsupportedOperation for ports that are not
+// USB adapters or whose vendor is not known.
+func (i *Info) ChipFamily() (string, error) {
+	vid, _, err := i.USBVIDPID()
+	if err != nil {
+		return "", err
+	}
+	if family, ok := chipFamilies[vid]; ok {
+		return family, nil
+	}
+	return "", ErrUnsupportedOperation
+}
+
 // Get the MAC address of a Bluetooth serial adapter port.
 func (i *Info) BluetoothAddress() string {
 	cdesc := C.sp_get_port_bluetooth_address(i.p)
@@ -420,6 +876,12 @@ func (i *Info) Open() (*Port, error) {
 
 // Open the port with the specified options.
 func (i *Info) OpenPort(options *Options) (*Port, error) {
+	// fill in options from the profile
+	options, err := options.withProfile()
+	if err != nil {
+		return nil, err
+	}
+
 	// create port
 	port, err := newPort(i)
 	if err != nil {
@@ -431,12 +893,22 @@ func (i *Info) OpenPort(options *Options) (*Port, error) {
 	if options.Mode != 0 {
 		mode = options.Mode
 	}
+	var orig *Termios
+	if options.Passive {
+		mode = MODE_READ
+		orig, _ = readTermios(i.Name())
+	}
 	if err = port.open(mode); err != nil {
 		return nil, err
 	}
+	port.bufSize = options.ReadBufferHint
 
-	// apply options
-	if err = port.Apply(options); err != nil {
+	// configure port
+	err = port.configure(options, orig)
+	if err == nil && options.OnOpen != nil {
+		err = options.OnOpen(port)
+	}
+	if err != nil {
 		port.Close()
 		return nil, err
 	}
@@ -444,6 +916,31 @@ func (i *Info) OpenPort(options *Options) (*Port, error) {
 	return port, nil
 }
 
+// Configure a newly opened port with the options. In passive mode, the
+// original terminal attributes are restored instead, if known.
+func (p *Port) configure(options *Options, orig *Termios) error {
+	// leave the port as it was found
+	if options.Passive {
+		if orig != nil {
+			return p.SetTermios(orig)
+		}
+		return nil
+	}
+
+	// apply options
+	if err := p.Apply(options); err != nil {
+		return err
+	}
+	if err := p.applyProfile(options.Profile); err != nil {
+		return err
+	}
+	if options.AbortOnParityError {
+		return p.SetAbortOnParityError(true)
+	}
+
+	return nil
+}
+
 func (i *Info) createPortAndInvalidateInfo() (*Port, error) {
 	port, err := newPort(nil)
 	if err != nil {
@@ -451,10 +948,10 @@ func (i *Info) createPortAndInvalidateInfo() (*Port, error) {
 	}
 
 	port.p = i.p
-	port.opened = i.opened
+	port.setOpen(i.isOpen())
 
 	i.p = nil
-	i.opened = false
+	i.setOpen(false)
 
 	return port, nil
 }
@@ -468,6 +965,60 @@ func (o *Options) Open(name string) (port *Port, err error) {
 	}
 }
 
+// Open a port at the given name using the options object, giving up
+// if the context is done first. A port that is still opening when the
+// context is done is closed once its open completes, since opening
+// cannot be interrupted.
+func OpenContext(ctx context.Context, name string, options *Options) (*Port, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		port *Port
+		err  error
+	}
+
+	ch := make(chan result, 1)
+	go func() {
+		port, err := options.Open(name)
+		ch <- result{port, err}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.port, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-ch; r.port != nil {
+				r.port.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// Open the only serial port on the system using the options object,
+// for single-device products that need no port name configuration.
+// Ports are listed with ListRealPorts, so that phantom ports are not
+// counted. Returns ErrNoPort if there are no ports and ErrAmbiguousPort
+// if there is more than one.
+func OpenOnly(options *Options) (*Port, error) {
+	ports, err := ListRealPorts()
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(ports) {
+	case 0:
+		return nil, ErrNoPort
+	case 1:
+		return ports[0].OpenPort(options)
+	default:
+		return nil, ErrAmbiguousPort
+	}
+}
+
 // Open a port at the given info using the options object.
 func (o *Options) OpenAt(info *Info) (port *Port, err error) {
 	return info.OpenPort(o)
@@ -478,7 +1029,7 @@ func Open(name string) (port *Port, err error) {
 	// get the port by name
 	if info, err := PortByName(name); err != nil {
 		return nil, err
-	} else if p, err := info.createPortAndInvalidateInfo(); p != nil {
+	} else if p, err := info.createPortAndInvalidateInfo(); err != nil {
 		return nil, err
 	} else {
 		port = p
@@ -493,45 +1044,233 @@ func Open(name string) (port *Port, err error) {
 }
 
 func (p *Port) open(mode int) error {
-	if p.opened {
-		panic("already opened")
+	if p.isOpen() {
+		return ErrAlreadyOpen
+	}
+	ret, errno := C.sp_open(p.p, C.enum_sp_mode(mode))
+	if err := errmsg("open", ret, errno); err != nil {
+		return err
 	}
-	err := errmsg(C.sp_open(p.p, C.enum_sp_mode(mode)))
-	p.opened = err == nil
+	p.setOpen(true)
+	p.mode = mode
+	p.done = make(chan struct{})
+	atomic.AddInt64(&openPorts, 1)
+
+	// keep the port from being inherited by child processes
+	if err := p.setCloseOnExec(true); err != nil && !errors.Is(err, ErrUnsupportedOperation) {
+		p.Close()
+		return err
+	}
+
 	return p.getConf()
 }
 
-// Close the serial port.
+// Set whether the port is closed in child processes started with exec.
+// The port is opened with close-on-exec set, so that child processes
+// do not inherit it and hold the device open; clear it to pass the port
+// to a child intentionally. Returns ErrUnsupportedOperation on
+// platforms without the flag; only Linux is currently supported.
+func (p *Port) SetCloseOnExec(enable bool) error {
+	return p.setCloseOnExec(enable)
+}
+
+// Run fn in a background goroutine tied to the lifetime of the port.
+// The done channel is closed when the port is closed, after which fn
+// must return promptly; Close waits for it to do so.
+func (p *Port) spawn(fn func(done <-chan struct{})) {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		fn(p.done)
+	}()
+}
+
+// Call a user callback from a background goroutine, counting it as
+// running so that Close, which may be called from the callback, does
+// not wait for the goroutine.
+func (p *Port) callback(fn func()) {
+	atomic.AddInt32(&p.callbacks, 1)
+	defer atomic.AddInt32(&p.callbacks, -1)
+	fn()
+}
+
+// Close the serial port. Background goroutines started for the port
+// are stopped and waited for before the port is closed. Reads and
+// writes in progress in other goroutines return ErrNotOpen, and the
+// port is closed once their current call into libserialport has
+// returned, within 100ms. Close may be called more than once and from
+// several goroutines; calls on a port that is not open return nil. If
+// the port cannot be closed, it is left open, without its background
+// goroutines, and the error is returned, so that Close can be retried.
+//
+// Close may be called from a callback, such as one passed to
+// OnSignalChange. While a callback is running, Close stops the
+// background goroutines but returns nil without waiting for them, and
+// the port is closed once they have returned; an error closing it is
+// not reported.
 func (p *Port) Close() error {
-	if !p.opened {
-		panic("already closed")
+	if !p.isOpen() {
+		return nil
+	}
+	p.stopOnce.Do(func() {
+		close(p.done)
+	})
+
+	// waiting here would deadlock if called from the callback
+	if atomic.LoadInt32(&p.callbacks) > 0 {
+		go func() {
+			p.wg.Wait()
+			p.release()
+		}()
+		return nil
+	}
+
+	p.wg.Wait()
+	return p.release()
+}
+
+// Close the port handle, once the background goroutines have stopped.
+func (p *Port) release() error {
+	p.cmu.Lock()
+	defer p.cmu.Unlock()
+
+	// mark the port closed first so that other callers never see it as
+	// open once sp_close has been called, and reopen it if that fails;
+	// reads and writes in progress see it closed after their current
+	// call, and are waited for
+	if !atomic.CompareAndSwapInt32(&p.opened, 1, 0) {
+		return nil
+	}
+	p.iomu.Lock()
+	ret, errno := C.sp_close(p.p)
+	p.iomu.Unlock()
+	if err := errmsg("close", ret, errno); err != nil {
+		p.setOpen(true)
+		return err
 	}
-	err := errmsg(C.sp_close(p.p))
-	p.opened = false
-	return err
+	atomic.AddInt64(&openPorts, -1)
+	return nil
+}
+
+// Check whether the port is open.
+func (p *Port) IsOpen() bool {
+	return p.isOpen()
+}
+
+// Number of ports currently open in the process.
+var openPorts int64
+
+// Get the number of ports currently open in the process. Ports that are
+// never closed are counted until the finalizer closes them. Useful to
+// detect ports leaked by a long-running process.
+func OpenPortCount() int {
+	return int(atomic.LoadInt64(&openPorts))
 }
 
 func (p *Port) getConf() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.loadConf()
+}
+
+// Read the live port configuration into the local configuration. Must
+// be called with mu held.
+func (p *Port) loadConf() error {
+	if !p.isOpen() {
+		return ErrNotOpen
+	}
 	if p.c == nil {
-		if err := errmsg(C.sp_new_config(&p.c)); err != nil {
+		ret, errno := C.sp_new_config(&p.c)
+		if err := errmsg("new_config", ret, errno); err != nil {
 			return err
 		}
 	}
-	return errmsg(C.sp_get_config(p.p, p.c))
+	ret, errno := C.sp_get_config(p.p, p.c)
+	return errmsg("get_config", ret, errno)
+}
+
+// Save a copy of the live port configuration.
+func (p *Port) saveConf() (*C.struct_sp_port_config, error) {
+	var conf *C.struct_sp_port_config
+	ret, errno := C.sp_new_config(&conf)
+	if err := errmsg("new_config", ret, errno); err != nil {
+		return nil, err
+	}
+	ret, errno = C.sp_get_config(p.p, conf)
+	if err := errmsg("get_config", ret, errno); err != nil {
+		C.sp_free_config(conf)
+		return nil, err
+	}
+	return conf, nil
+}
+
+// Apply and free a configuration saved with saveConf. Must be called
+// with cmu held.
+func (p *Port) restoreConf(conf *C.struct_sp_port_config) error {
+	defer C.sp_free_config(conf)
+	p.iomu.Lock()
+	ret, errno := C.sp_set_config(p.p, conf)
+	p.iomu.Unlock()
+	if err := errmsg("set_config", ret, errno); err != nil {
+		return err
+	}
+	return p.getConf()
+}
+
+// Reset the port configuration to 9600 8N1 without flow control, for a
+// known baseline regardless of earlier changes.
+func (p *Port) ResetConfig() error {
+	return p.Apply(&Options{
+		BitRate:     9600,
+		DataBits:    8,
+		Parity:      PARITY_NONE,
+		StopBits:    1,
+		FlowControl: FLOWCONTROL_NONE,
+	})
+}
+
+// Copy the configuration of the port to another opened port, so that a
+// spare adapter can be set up identically to the primary before
+// switching over to it.
+func (p *Port) CloneConfigTo(other *Port) error {
+	conf, err := p.saveConf()
+	if err != nil {
+		return err
+	}
+	other.cmu.Lock()
+	defer other.cmu.Unlock()
+	return other.restoreConf(conf)
+}
+
+// Apply port options to an open port, committing the bit rate, data
+// bits, stop bits, parity, flow control and pin behaviours with a
+// single configuration call. Fields left zero leave the corresponding
+// setting unchanged rather than resetting it to a default. This differs
+// from opening a port, where the profile, if any, fills in the unset
+// fields first; here Profile, Mode, Passive and the other open-time
+// options are ignored. A bit rate that reads back more than 2% away
+// from the one asked for is reported as UnsupportedBitRateError, with
+// the previous rate restored.
+func (p *Port) Apply(o *Options) error {
+	p.cmu.Lock()
+	defer p.cmu.Unlock()
+	return p.apply(o)
 }
 
-// Apply port options.
-func (p *Port) Apply(o *Options) (err error) {
+// Apply port options. Must be called with cmu held.
+func (p *Port) apply(o *Options) (err error) {
 	// get port config
 	var conf *C.struct_sp_port_config
-	if err = errmsg(C.sp_new_config(&conf)); err != nil {
+	ret, errno := C.sp_new_config(&conf)
+	if err = errmsg("new_config", ret, errno); err != nil {
 		return
 	}
 	defer C.sp_free_config(conf)
 
 	// set bit rate
 	if o.BitRate != 0 {
-		err = errmsg(C.sp_set_config_baudrate(conf, C.int(o.BitRate)))
+		ret, errno := C.sp_set_config_baudrate(conf, C.int(o.BitRate))
+		err = errmsg("set_config_baudrate", ret, errno)
 		if err != nil {
 			return
 		}
@@ -539,7 +1278,8 @@ func (p *Port) Apply(o *Options) (err error) {
 
 	// set data bits
 	if o.DataBits != 0 {
-		err = errmsg(C.sp_set_config_bits(conf, C.int(o.DataBits)))
+		ret, errno := C.sp_set_config_bits(conf, C.int(o.DataBits))
+		err = errmsg("set_config_bits", ret, errno)
 		if err != nil {
 			return
 		}
@@ -547,7 +1287,8 @@ func (p *Port) Apply(o *Options) (err error) {
 
 	// set stop bits
 	if o.StopBits != 0 {
-		err = errmsg(C.sp_set_config_stopbits(conf, C.int(o.StopBits)))
+		ret, errno := C.sp_set_config_stopbits(conf, C.int(o.StopBits))
+		err = errmsg("set_config_stopbits", ret, errno)
 		if err != nil {
 			return
 		}
@@ -556,7 +1297,8 @@ func (p *Port) Apply(o *Options) (err error) {
 	// set parity
 	if o.Parity != 0 {
 		cparity := parity2c(o.Parity)
-		if err = errmsg(C.sp_set_config_parity(conf, cparity)); err != nil {
+		ret, errno := C.sp_set_config_parity(conf, cparity)
+		if err = errmsg("set_config_parity", ret, errno); err != nil {
 			return
 		}
 	}
@@ -567,7 +1309,8 @@ func (p *Port) Apply(o *Options) (err error) {
 		if err != nil {
 			return err
 		}
-		if err = errmsg(C.sp_set_config_flowcontrol(conf, cfc)); err != nil {
+		ret, errno := C.sp_set_config_flowcontrol(conf, cfc)
+		if err = errmsg("set_config_flowcontrol", ret, errno); err != nil {
 			return err
 		}
 	}
@@ -575,7 +1318,8 @@ func (p *Port) Apply(o *Options) (err error) {
 	// set RTS
 	if o.RTS != 0 {
 		crts := rts2c(o.RTS)
-		if err = errmsg(C.sp_set_config_rts(conf, crts)); err != nil {
+		ret, errno := C.sp_set_config_rts(conf, crts)
+		if err = errmsg("set_config_rts", ret, errno); err != nil {
 			return
 		}
 	}
@@ -583,7 +1327,8 @@ func (p *Port) Apply(o *Options) (err error) {
 	// set CTS
 	if o.CTS != 0 {
 		ccts := cts2c(o.CTS)
-		if err = errmsg(C.sp_set_config_cts(conf, ccts)); err != nil {
+		ret, errno := C.sp_set_config_cts(conf, ccts)
+		if err = errmsg("set_config_cts", ret, errno); err != nil {
 			return
 		}
 	}
@@ -591,7 +1336,8 @@ func (p *Port) Apply(o *Options) (err error) {
 	// set DTR
 	if o.DTR != 0 {
 		cdtr := dtr2c(o.DTR)
-		if err = errmsg(C.sp_set_config_dtr(conf, cdtr)); err != nil {
+		ret, errno := C.sp_set_config_dtr(conf, cdtr)
+		if err = errmsg("set_config_dtr", ret, errno); err != nil {
 			return
 		}
 	}
@@ -599,64 +1345,343 @@ func (p *Port) Apply(o *Options) (err error) {
 	// set DSR
 	if o.DSR != 0 {
 		cdsr := dsr2c(o.DSR)
-		if err = errmsg(C.sp_set_config_dsr(conf, cdsr)); err != nil {
+		ret, errno := C.sp_set_config_dsr(conf, cdsr)
+		if err = errmsg("set_config_dsr", ret, errno); err != nil {
+			return
+		}
+	}
+
+	// remember the bit rate, to restore it if the new one does not
+	// take effect
+	var previous int
+	if o.BitRate != 0 {
+		if previous, err = p.BitRate(); err != nil {
 			return
 		}
 	}
 
 	// apply config
-	if err = errmsg(C.sp_set_config(p.p, conf)); err != nil {
+	p.iomu.Lock()
+	ret, errno = C.sp_set_config(p.p, conf)
+	p.iomu.Unlock()
+	if err = errmsg("set_config", ret, errno); err != nil {
+		if o.BitRate != 0 && !o.mayBeUnsupported() {
+			err = bitRateErr(err, o.BitRate)
+		}
 		return
 	}
 
 	// update local config
-	return p.getConf()
-}
+	if err = p.getConf(); err != nil {
+		return
+	}
 
-// Get the baud rate from a port configuration. The port must be
-// opened for this operation.
-func (p *Port) BitRate() (int, error) {
-	var bitrate C.int
-	if err := errmsg(C.sp_get_config_baudrate(p.c, &bitrate)); err != nil {
-		return 0, err
+	// check that the bit rate took effect
+	if o.BitRate != 0 {
+		return p.checkBitRate(o.BitRate, previous)
 	}
-	return int(bitrate), nil
+
+	return nil
 }
 
-// Set the baud rate for the serial port. The port must be opened for
-// this operation. Call p.ApplyConfig() to apply the change.
-func (p *Port) SetBitRate(bitrate int) error {
-	if err := errmsg(C.sp_set_baudrate(p.p, C.int(bitrate))); err != nil {
+// Apply port options, restoring the previous configuration if any of
+// them fails, so that the port is not left partially reconfigured.
+// The settings are applied one at a time by the driver, and a failure
+// such as an unsupported parity setting may follow a bit rate change
+// that succeeded. The error from applying the options is returned, or
+// a RollbackError wrapping it if the previous configuration cannot be
+// restored either.
+func (p *Port) ApplyAtomic(o *Options) error {
+	p.cmu.Lock()
+	defer p.cmu.Unlock()
+
+	saved, err := p.saveConf()
+	if err != nil {
 		return err
 	}
-	return p.getConf()
-}
 
-// Get the data bits from a port configuration. The port must be
-// opened for this operation.
-func (p *Port) DataBits() (int, error) {
-	var bits C.int
-	if err := errmsg(C.sp_get_config_bits(p.c, &bits)); err != nil {
-		return 0, err
+	if err := p.apply(o); err != nil {
+		if rerr := p.restoreConf(saved); rerr != nil {
+			return &RollbackError{Err: err, Rollback: rerr}
+		}
+		return err
 	}
-	return int(bits), nil
+
+	C.sp_free_config(saved)
+	return nil
 }
 
-// Set the number of data bits for the serial port. The port must be
-// opened for this operation. Call p.ApplyConfig() to apply the
-// change.
-func (p *Port) SetDataBits(bits int) error {
-	if err := errmsg(C.sp_set_config_bits(p.c, C.int(bits))); err != nil {
-		return err
+// Get the options that reproduce the current port configuration, read
+// from the port in a single pass, so that the port can be reconfigured
+// and later restored by passing them to Apply. The flow control is only
+// set if it is one of the standard combinations; the pin behaviours are
+// always set. Returns the first error encountered.
+//
+// Only the settings that Options covers are returned. Others, such as
+// non-standard XON and XOFF characters, are not preserved; save and
+// restore them with Termios and SetTermios on Linux.
+func (p *Port) GetConfig() (o Options, err error) {
+	o.Mode = p.mode
+	o.ReadBufferHint = p.bufSize
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err = p.loadConf(); err != nil {
+		return
 	}
-	return p.getConf()
-}
 
-// Get the stop bits from a port configuration. The port must be
-// opened for this operation.
-func (p *Port) StopBits() (int, error) {
+	var bitrate, bits, stopbits C.int
+	ret, errno := C.sp_get_config_baudrate(p.c, &bitrate)
+	if err = errmsg("get_config_baudrate", ret, errno); err != nil {
+		return
+	}
+	ret, errno = C.sp_get_config_bits(p.c, &bits)
+	if err = errmsg("get_config_bits", ret, errno); err != nil {
+		return
+	}
+	ret, errno = C.sp_get_config_stopbits(p.c, &stopbits)
+	if err = errmsg("get_config_stopbits", ret, errno); err != nil {
+		return
+	}
+	cparity := C.enum_sp_parity(C.SP_PARITY_INVALID)
+	ret, errno = C.sp_get_config_parity(p.c, &cparity)
+	if err = errmsg("get_config_parity", ret, errno); err != nil {
+		return
+	}
+
+	d, err := p.flowDetail()
+	if err != nil {
+		return
+	}
+
+	o.BitRate, o.DataBits, o.StopBits = int(bitrate), int(bits), int(stopbits)
+	o.Parity = c2parity(cparity)
+	o.FlowControl = pins2flow(d.RTS, d.CTS, d.DTR, d.DSR, d.XonXoff)
+	o.RTS, o.CTS, o.DTR, o.DSR = d.RTS, d.CTS, d.DTR, d.DSR
+	return
+}
+
+// Get the terminal attributes of the port, for settings that the port
+// configuration does not cover. Returns ErrUnsupportedOperation on
+// platforms without termios support; only Linux is currently
+// supported.
+func (p *Port) Termios() (*Termios, error) {
+	return p.getTermios()
+}
+
+// Set the terminal attributes of the port. Returns
+// ErrUnsupportedOperation on platforms without termios support.
+func (p *Port) SetTermios(t *Termios) error {
+	p.cmu.Lock()
+	defer p.cmu.Unlock()
+	return p.applyTermios(t)
+}
+
+// Set the terminal attributes and update the local configuration. Must
+// be called with cmu held.
+func (p *Port) applyTermios(t *Termios) error {
+	p.iomu.Lock()
+	err := p.setTermios(t)
+	p.iomu.Unlock()
+	if err != nil {
+		return err
+	}
+	return p.getConf()
+}
+
+// Modify the terminal attributes with fn and apply them.
+func (p *Port) updateTermios(fn func(t *Termios)) error {
+	p.cmu.Lock()
+	defer p.cmu.Unlock()
+	return p.modifyTermios(fn)
+}
+
+// Modify the terminal attributes with fn and apply them. Must be called
+// with cmu held.
+func (p *Port) modifyTermios(fn func(t *Termios)) error {
+	t, err := p.getTermios()
+	if err != nil {
+		return err
+	}
+	fn(t)
+	return p.applyTermios(t)
+}
+
+// Set how breaks received on the input are handled, so that a break
+// used as a frame boundary can be detected in the data read. In
+// BREAK_MARK mode, parity and framing errors are also marked, as 0xFF
+// 0x00 followed by the received byte, when parity checking is enabled,
+// and a 0xFF data byte is read as 0xFF 0xFF. Returns
+// ErrUnsupportedOperation on platforms without termios support.
+func (p *Port) SetBreakHandling(mode int) error {
+	switch mode {
+	case BREAK_NUL, BREAK_IGNORE, BREAK_MARK:
+		return p.setBreakHandling(mode)
+	}
+	return ErrInvalidArguments
+}
+
+// Get how breaks received on the input are handled. Returns
+// BREAK_INVALID if breaks raise a signal rather than appear in the
+// input.
+func (p *Port) BreakHandling() (int, error) {
+	return p.breakHandling()
+}
+
+// Run fn with scoped access to the terminal attributes. fn receives a
+// copy of the current attributes, which it may modify and apply with
+// SetTermios before performing I/O. The changes fn leaves in the copy
+// are applied when it returns, so that an invalid change is reported
+// even if fn did not apply it. The original attributes are then
+// restored, as they are if fn fails or panics.
+func (p *Port) WithRawTermios(fn func(t *Termios) error) (err error) {
+	orig, err := p.getTermios()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if rerr := p.SetTermios(orig); err == nil {
+			err = rerr
+		}
+	}()
+
+	t := *orig
+	if err = fn(&t); err != nil {
+		return err
+	}
+	return p.SetTermios(&t)
+}
+
+// Get the baud rate from a port configuration. The port must be opened
+// for this operation, or ErrNotOpen is returned.
+func (p *Port) BitRate() (int, error) {
+	if !p.isOpen() {
+		return 0, ErrNotOpen
+	}
+	var bitrate C.int
+	p.mu.Lock()
+	ret, errno := C.sp_get_config_baudrate(p.c, &bitrate)
+	err := errmsg("get_config_baudrate", ret, errno)
+	p.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	return int(bitrate), nil
+}
+
+// Set the baud rate for the serial port. The port must be opened for
+// this operation. Call p.ApplyConfig() to apply the change.
+// Non-standard rates, such as 250000 for DMX512, are set with the
+// termios2 interface on Linux, where the driver picks the nearest
+// divisor it can; BitRate reports the rate set. Returns
+// UnsupportedBitRateError, with the previous rate restored, if the rate
+// is not supported or reads back more than 2% away from the one asked
+// for.
+func (p *Port) SetBitRate(bitrate int) error {
+	p.cmu.Lock()
+	defer p.cmu.Unlock()
+
+	previous, err := p.BitRate()
+	if err != nil {
+		return err
+	}
+
+	p.iomu.Lock()
+	ret, errno := C.sp_set_baudrate(p.p, C.int(bitrate))
+	p.iomu.Unlock()
+	if err := errmsg("set_baudrate", ret, errno); err != nil {
+		return bitRateErr(err, bitrate)
+	}
+	if err := p.getConf(); err != nil {
+		return err
+	}
+	return p.checkBitRate(bitrate, previous)
+}
+
+// Get the maximum packet size of the USB bulk endpoints of a USB serial
+// adapter port, typically 64 bytes at full speed and 512 bytes at high
+// speed, so that reads and writes can be sized to whole packets.
+// Returns ErrUnsupportedOperation for ports that are not USB adapters
+// or where the size is not available; only Linux is currently
+// supported.
+func (p *Port) USBPacketSize() (int, error) {
+	if p.Transport() != TRANSPORT_USB {
+		return 0, ErrUnsupportedOperation
+	}
+	return usbPacketSize(p.Name())
+}
+
+// Attach a kernel line discipline to the port, such as LDISC_PPS for
+// pulse per second timing from a GPS receiver or LDISC_SLIP. Data read
+// and written is processed by the line discipline; attach LDISC_TTY to
+// return to normal operation. Returns ErrUnsupportedOperation off
+// Linux.
+func (p *Port) SetLineDiscipline(ld int) error {
+	if ld < 0 {
+		return ErrInvalidArguments
+	}
+	return p.setLineDiscipline(ld)
+}
+
+// Get the kernel line discipline attached to the port. Returns
+// ErrUnsupportedOperation off Linux.
+func (p *Port) LineDiscipline() (int, error) {
+	return p.lineDiscipline()
+}
+
+// Get the base clock rate the driver divides to generate the bit rate,
+// for diagnostics. Achievable custom rates are baud_base / divisor for
+// integer divisors. Returns ErrUnsupportedOperation if the driver does
+// not report it; only Linux is currently supported.
+func (p *Port) BaudBase() (int, error) {
+	return p.baudBase()
+}
+
+// Get the data bits from a port configuration. The port must be opened
+// for this operation, or ErrNotOpen is returned.
+func (p *Port) DataBits() (int, error) {
+	if !p.isOpen() {
+		return 0, ErrNotOpen
+	}
+	var bits C.int
+	p.mu.Lock()
+	ret, errno := C.sp_get_config_bits(p.c, &bits)
+	err := errmsg("get_config_bits", ret, errno)
+	p.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	return int(bits), nil
+}
+
+// Set the number of data bits for the serial port. The port must be
+// opened for this operation. Call p.ApplyConfig() to apply the
+// change.
+func (p *Port) SetDataBits(bits int) error {
+	p.mu.Lock()
+	ret, errno := C.sp_set_config_bits(p.c, C.int(bits))
+	err := errmsg("set_config_bits", ret, errno)
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return p.getConf()
+}
+
+// Get the stop bits from a port configuration. The port must be opened
+// for this operation, or ErrNotOpen is returned.
+func (p *Port) StopBits() (int, error) {
+	if !p.isOpen() {
+		return 0, ErrNotOpen
+	}
 	var stopbits C.int
-	if err := errmsg(C.sp_get_config_stopbits(p.c, &stopbits)); err != nil {
+	p.mu.Lock()
+	ret, errno := C.sp_get_config_stopbits(p.c, &stopbits)
+	err := errmsg("get_config_stopbits", ret, errno)
+	p.mu.Unlock()
+	if err != nil {
 		return 0, err
 	}
 	return int(stopbits), nil
@@ -665,17 +1690,28 @@ func (p *Port) StopBits() (int, error) {
 // Set the stop bits for the serial port. The port must be opened for
 // this operation. Call p.ApplyConfig() to apply the change.
 func (p *Port) SetStopBits(stopbits int) error {
-	if err := errmsg(C.sp_set_config_stopbits(p.c, C.int(stopbits))); err != nil {
+	p.mu.Lock()
+	ret, errno := C.sp_set_config_stopbits(p.c, C.int(stopbits))
+	err := errmsg("set_config_stopbits", ret, errno)
+	p.mu.Unlock()
+	if err != nil {
 		return err
 	}
 	return p.getConf()
 }
 
 // Get the parity setting from a port configuration. The port must be
-// opened for this operation.
-func (p *Port) Parity() (int, error) {
+// opened for this operation, or ErrNotOpen is returned.
+func (p *Port) Parity() (Parity, error) {
+	if !p.isOpen() {
+		return 0, ErrNotOpen
+	}
 	cparity := C.enum_sp_parity(C.SP_PARITY_INVALID)
-	if err := errmsg(C.sp_get_config_parity(p.c, &cparity)); err != nil {
+	p.mu.Lock()
+	ret, errno := C.sp_get_config_parity(p.c, &cparity)
+	err := errmsg("get_config_parity", ret, errno)
+	p.mu.Unlock()
+	if err != nil {
 		return 0, err
 	}
 	return c2parity(cparity), nil
@@ -683,15 +1719,50 @@ func (p *Port) Parity() (int, error) {
 
 // Set the parity setting for the serial port. The port must be opened
 // for this operation. Call p.ApplyConfig() to apply the change.
-func (p *Port) SetParity(parity int) error {
+func (p *Port) SetParity(parity Parity) error {
 	cparity := parity2c(parity)
-	if err := errmsg(C.sp_set_config_parity(p.c, cparity)); err != nil {
+	p.mu.Lock()
+	ret, errno := C.sp_set_config_parity(p.c, cparity)
+	err := errmsg("set_config_parity", ret, errno)
+	p.mu.Unlock()
+	if err != nil {
 		return err
 	}
 	return p.getConf()
 }
 
-func c2parity(cparity C.enum_sp_parity) int {
+// Get the number of bits in each character frame on the line: the start
+// bit, data bits, parity bit and stop bits of the port configuration,
+// such as 10 for 8N1 or 11 for 7E2. Divide by the bit rate for the time
+// taken to transmit a character. The port must be opened for this
+// operation. Returns ErrUnknownFraming if the terminal attributes of the
+// port do not correspond to a data bits or parity setting.
+func (p *Port) BitsPerFrame() (int, error) {
+	bits, err := p.DataBits()
+	if err != nil {
+		return 0, err
+	}
+	stopbits, err := p.StopBits()
+	if err != nil {
+		return 0, err
+	}
+	parity, err := p.Parity()
+	if err != nil {
+		return 0, err
+	}
+	if bits <= 0 || parity == PARITY_INVALID {
+		return 0, ErrUnknownFraming
+	}
+
+	n := 1 + bits + stopbits
+	if parity != PARITY_NONE {
+		n++
+	}
+
+	return n, nil
+}
+
+func c2parity(cparity C.enum_sp_parity) Parity {
 	switch cparity {
 	case C.SP_PARITY_NONE:
 		return PARITY_NONE
@@ -708,7 +1779,7 @@ func c2parity(cparity C.enum_sp_parity) int {
 	}
 }
 
-func parity2c(parity int) C.enum_sp_parity {
+func parity2c(parity Parity) C.enum_sp_parity {
 	switch parity {
 	case PARITY_NONE:
 		return C.SP_PARITY_NONE
@@ -725,11 +1796,18 @@ func parity2c(parity int) C.enum_sp_parity {
 	}
 }
 
-// Get the RTS pin behaviour from a port configuration. The port must
-// be opened for this operation.
-func (p *Port) RTS() (int, error) {
+// Get the RTS pin behaviour from a port configuration. The port must be
+// opened for this operation, or ErrNotOpen is returned.
+func (p *Port) RTS() (RTSMode, error) {
+	if !p.isOpen() {
+		return 0, ErrNotOpen
+	}
 	rts := C.enum_sp_rts(C.SP_RTS_INVALID)
-	if err := errmsg(C.sp_get_config_rts(p.c, &rts)); err != nil {
+	p.mu.Lock()
+	ret, errno := C.sp_get_config_rts(p.c, &rts)
+	err := errmsg("get_config_rts", ret, errno)
+	p.mu.Unlock()
+	if err != nil {
 		return 0, err
 	}
 	return c2rts(rts), nil
@@ -738,15 +1816,36 @@ func (p *Port) RTS() (int, error) {
 // Set the RTS pin behaviour in a port configuration. The port must be
 // opened for this operation. Call p.ApplyConfig() to apply the
 // change.
-func (p *Port) SetRTS(rts int) error {
+func (p *Port) SetRTS(rts RTSMode) error {
 	crts := rts2c(rts)
-	if err := errmsg(C.sp_set_config_rts(p.c, crts)); err != nil {
+	p.mu.Lock()
+	ret, errno := C.sp_set_config_rts(p.c, crts)
+	err := errmsg("set_config_rts", ret, errno)
+	p.mu.Unlock()
+	if err != nil {
 		return err
 	}
 	return p.getConf()
 }
 
-func c2rts(rts C.enum_sp_rts) int {
+// Check whether the adapter supports manual control of the RTS and
+// DTR lines, by probing for the modem control line state. Some USB
+// adapters do not expose the lines, in which case setting them has no
+// effect. Returns ErrUnsupportedOperation where support cannot be
+// probed; only Linux is currently supported.
+func (p *Port) SupportsPinControl() (bool, error) {
+	return p.supportsPinControl()
+}
+
+// Get the live state of the RTS output line, as reported by the
+// driver rather than the port configuration. Returns
+// ErrUnsupportedOperation where the line state cannot be queried.
+func (p *Port) RTSState() (bool, error) {
+	lines, err := p.modemLines()
+	return lines&lineRTS != 0, err
+}
+
+func c2rts(rts C.enum_sp_rts) RTSMode {
 	switch rts {
 	case C.SP_RTS_OFF:
 		return RTS_OFF
@@ -759,7 +1858,7 @@ func c2rts(rts C.enum_sp_rts) int {
 	}
 }
 
-func rts2c(rts int) C.enum_sp_rts {
+func rts2c(rts RTSMode) C.enum_sp_rts {
 	switch rts {
 	case RTS_OFF:
 		return C.SP_RTS_OFF
@@ -772,11 +1871,18 @@ func rts2c(rts int) C.enum_sp_rts {
 	}
 }
 
-// Get the CTS pin behaviour from a port configuration. The port must
-// be opened for this operation.
-func (p *Port) CTS() (int, error) {
+// Get the CTS pin behaviour from a port configuration. The port must be
+// opened for this operation, or ErrNotOpen is returned.
+func (p *Port) CTS() (CTSMode, error) {
+	if !p.isOpen() {
+		return 0, ErrNotOpen
+	}
 	cts := C.enum_sp_cts(C.SP_CTS_INVALID)
-	if err := errmsg(C.sp_get_config_cts(p.c, &cts)); err != nil {
+	p.mu.Lock()
+	ret, errno := C.sp_get_config_cts(p.c, &cts)
+	err := errmsg("get_config_cts", ret, errno)
+	p.mu.Unlock()
+	if err != nil {
 		return 0, err
 	}
 	return c2cts(cts), nil
@@ -785,15 +1891,19 @@ func (p *Port) CTS() (int, error) {
 // Set the CTS pin behaviour in a port configuration. The port must be
 // opened for this operation. Call p.ApplyConfig() to apply the
 // change.
-func (p *Port) SetCTS(cts int) error {
+func (p *Port) SetCTS(cts CTSMode) error {
 	ccts := cts2c(cts)
-	if err := errmsg(C.sp_set_config_cts(p.c, ccts)); err != nil {
+	p.mu.Lock()
+	ret, errno := C.sp_set_config_cts(p.c, ccts)
+	err := errmsg("set_config_cts", ret, errno)
+	p.mu.Unlock()
+	if err != nil {
 		return err
 	}
 	return p.getConf()
 }
 
-func c2cts(cts C.enum_sp_cts) int {
+func c2cts(cts C.enum_sp_cts) CTSMode {
 	switch cts {
 	case C.SP_CTS_IGNORE:
 		return CTS_IGNORE
@@ -804,7 +1914,7 @@ func c2cts(cts C.enum_sp_cts) int {
 	}
 }
 
-func cts2c(cts int) C.enum_sp_cts {
+func cts2c(cts CTSMode) C.enum_sp_cts {
 	switch cts {
 	case CTS_IGNORE:
 		return C.SP_CTS_IGNORE
@@ -815,11 +1925,18 @@ func cts2c(cts int) C.enum_sp_cts {
 	}
 }
 
-// Get the DTR pin behaviour from a port configuration. The port must
-// be opened for this operation.
-func (p *Port) DTR() (int, error) {
+// Get the DTR pin behaviour from a port configuration. The port must be
+// opened for this operation, or ErrNotOpen is returned.
+func (p *Port) DTR() (DTRMode, error) {
+	if !p.isOpen() {
+		return 0, ErrNotOpen
+	}
 	dtr := C.enum_sp_dtr(C.SP_DTR_INVALID)
-	if err := errmsg(C.sp_get_config_dtr(p.c, &dtr)); err != nil {
+	p.mu.Lock()
+	ret, errno := C.sp_get_config_dtr(p.c, &dtr)
+	err := errmsg("get_config_dtr", ret, errno)
+	p.mu.Unlock()
+	if err != nil {
 		return 0, err
 	}
 	return c2dtr(dtr), nil
@@ -828,15 +1945,27 @@ func (p *Port) DTR() (int, error) {
 // Set the DTR pin behaviour in a port configuration. The port must be
 // opened for this operation. Call p.ApplyConfig() to apply the
 // change.
-func (p *Port) SetDTR(dtr int) error {
+func (p *Port) SetDTR(dtr DTRMode) error {
 	cdtr := dtr2c(dtr)
-	if err := errmsg(C.sp_set_config_dtr(p.c, cdtr)); err != nil {
+	p.mu.Lock()
+	ret, errno := C.sp_set_config_dtr(p.c, cdtr)
+	err := errmsg("set_config_dtr", ret, errno)
+	p.mu.Unlock()
+	if err != nil {
 		return err
 	}
 	return p.getConf()
 }
 
-func c2dtr(dtr C.enum_sp_dtr) int {
+// Get the live state of the DTR output line, as reported by the
+// driver rather than the port configuration. Returns
+// ErrUnsupportedOperation where the line state cannot be queried.
+func (p *Port) DTRState() (bool, error) {
+	lines, err := p.modemLines()
+	return lines&lineDTR != 0, err
+}
+
+func c2dtr(dtr C.enum_sp_dtr) DTRMode {
 	switch dtr {
 	case C.SP_DTR_OFF:
 		return DTR_OFF
@@ -849,7 +1978,7 @@ func c2dtr(dtr C.enum_sp_dtr) int {
 	}
 }
 
-func dtr2c(dtr int) C.enum_sp_dtr {
+func dtr2c(dtr DTRMode) C.enum_sp_dtr {
 	switch dtr {
 	case DTR_OFF:
 		return C.SP_DTR_OFF
@@ -862,11 +1991,18 @@ func dtr2c(dtr int) C.enum_sp_dtr {
 	}
 }
 
-// Get the DSR pin behaviour from a port configuration. The port must
-// be opened for this operation.
-func (p *Port) DSR() (int, error) {
+// Get the DSR pin behaviour from a port configuration. The port must be
+// opened for this operation, or ErrNotOpen is returned.
+func (p *Port) DSR() (DSRMode, error) {
+	if !p.isOpen() {
+		return 0, ErrNotOpen
+	}
 	dsr := C.enum_sp_dsr(C.SP_DSR_INVALID)
-	if err := errmsg(C.sp_get_config_dsr(p.c, &dsr)); err != nil {
+	p.mu.Lock()
+	ret, errno := C.sp_get_config_dsr(p.c, &dsr)
+	err := errmsg("get_config_dsr", ret, errno)
+	p.mu.Unlock()
+	if err != nil {
 		return 0, err
 	}
 	return c2dsr(dsr), nil
@@ -875,15 +2011,19 @@ func (p *Port) DSR() (int, error) {
 // Set the DSR pin behaviour in a port configuration. The port must be
 // opened for this operation. Call p.ApplyConfig() to apply the
 // change.
-func (p *Port) SetDSR(dsr int) error {
+func (p *Port) SetDSR(dsr DSRMode) error {
 	cdsr := dsr2c(dsr)
-	if err := errmsg(C.sp_set_config_dsr(p.c, cdsr)); err != nil {
+	p.mu.Lock()
+	ret, errno := C.sp_set_config_dsr(p.c, cdsr)
+	err := errmsg("set_config_dsr", ret, errno)
+	p.mu.Unlock()
+	if err != nil {
 		return err
 	}
 	return p.getConf()
 }
 
-func c2dsr(dsr C.enum_sp_dsr) int {
+func c2dsr(dsr C.enum_sp_dsr) DSRMode {
 	switch dsr {
 	case C.SP_DSR_IGNORE:
 		return DSR_IGNORE
@@ -894,7 +2034,7 @@ func c2dsr(dsr C.enum_sp_dsr) int {
 	}
 }
 
-func dsr2c(dsr int) C.enum_sp_dsr {
+func dsr2c(dsr DSRMode) C.enum_sp_dsr {
 	switch dsr {
 	case DSR_IGNORE:
 		return C.SP_DSR_IGNORE
@@ -906,10 +2046,17 @@ func dsr2c(dsr int) C.enum_sp_dsr {
 }
 
 // Get the XON/XOFF configuration from a port configuration. The port
-// must be opened for this operation.
-func (p *Port) XonXoff() (int, error) {
+// must be opened for this operation, or ErrNotOpen is returned.
+func (p *Port) XonXoff() (XonXoffMode, error) {
+	if !p.isOpen() {
+		return 0, ErrNotOpen
+	}
 	xon := C.enum_sp_xonxoff(C.SP_XONXOFF_INVALID)
-	if err := errmsg(C.sp_get_config_xon_xoff(p.c, &xon)); err != nil {
+	p.mu.Lock()
+	ret, errno := C.sp_get_config_xon_xoff(p.c, &xon)
+	err := errmsg("get_config_xon_xoff", ret, errno)
+	p.mu.Unlock()
+	if err != nil {
 		return 0, err
 	}
 	return c2xon(xon), nil
@@ -918,15 +2065,19 @@ func (p *Port) XonXoff() (int, error) {
 // Set the XON/XOFF configuration in a port configuration. The port
 // must be opened for this operation. Call p.ApplyConfig() to apply
 // the change.
-func (p *Port) SetXonXoff(xon int) error {
+func (p *Port) SetXonXoff(xon XonXoffMode) error {
 	cxon := xon2c(xon)
-	if err := errmsg(C.sp_set_config_xon_xoff(p.c, cxon)); err != nil {
+	p.mu.Lock()
+	ret, errno := C.sp_set_config_xon_xoff(p.c, cxon)
+	err := errmsg("set_config_xon_xoff", ret, errno)
+	p.mu.Unlock()
+	if err != nil {
 		return err
 	}
 	return p.getConf()
 }
 
-func c2xon(xon C.enum_sp_xonxoff) int {
+func c2xon(xon C.enum_sp_xonxoff) XonXoffMode {
 	switch xon {
 	case C.SP_XONXOFF_DISABLED:
 		return XONXOFF_DISABLED
@@ -934,12 +2085,14 @@ func c2xon(xon C.enum_sp_xonxoff) int {
 		return XONXOFF_IN
 	case C.SP_XONXOFF_OUT:
 		return XONXOFF_OUT
+	case C.SP_XONXOFF_INOUT:
+		return XONXOFF_INOUT
 	default:
 		return XONXOFF_INVALID
 	}
 }
 
-func xon2c(xon int) C.enum_sp_xonxoff {
+func xon2c(xon XonXoffMode) C.enum_sp_xonxoff {
 	switch xon {
 	case XONXOFF_DISABLED:
 		return C.SP_XONXOFF_DISABLED
@@ -947,28 +2100,206 @@ func xon2c(xon int) C.enum_sp_xonxoff {
 		return C.SP_XONXOFF_IN
 	case XONXOFF_OUT:
 		return C.SP_XONXOFF_OUT
+	case XONXOFF_INOUT:
+		return C.SP_XONXOFF_INOUT
 	default:
 		return C.SP_XONXOFF_INVALID
 	}
 }
 
+// Recover from a stuck XON/XOFF flow control state, such as when line
+// noise corrupts an XON. Output suspended by a received XOFF is
+// resumed, and an XON is sent so that a paused peer resumes sending.
+// Does nothing on ports without XON/XOFF flow control.
+func (p *Port) ClearFlowControlHold() error {
+	xon, err := p.XonXoff()
+	if err != nil {
+		return err
+	}
+	if xon == XONXOFF_DISABLED || xon == XONXOFF_INVALID {
+		return nil
+	}
+	return p.clearFlowHold(xon)
+}
+
+// Check whether output is held by flow control: CTS is deasserted with
+// CTS flow control, DSR is deasserted with DSR flow control, or, with
+// XON/XOFF output flow control, the output buffer holds data that is
+// not draining. Drivers do not report a received XOFF, so the output
+// buffer is sampled over the time taken to transmit a few characters
+// to detect it; the call blocks for that long when data is waiting.
+func (p *Port) OutputBlocked() (bool, error) {
+	d, err := p.FlowControlDetail()
+	if err != nil {
+		return false, err
+	}
+
+	// hardware flow control
+	if d.CTS == CTS_FLOW_CONTROL || d.DSR == DSR_FLOW_CONTROL {
+		sigs, err := p.signals()
+		if err != nil {
+			return false, err
+		}
+		if d.CTS == CTS_FLOW_CONTROL && sigs&SIG_CTS == 0 ||
+			d.DSR == DSR_FLOW_CONTROL && sigs&SIG_DSR == 0 {
+			return true, nil
+		}
+	}
+
+	// software flow control
+	if d.XonXoff != XONXOFF_OUT && d.XonXoff != XONXOFF_INOUT {
+		return false, nil
+	}
+
+	before, err := p.OutputWaiting()
+	if err != nil || before == 0 {
+		return false, err
+	}
+
+	wait := 10 * time.Millisecond
+	if bits, err := p.BitsPerFrame(); err == nil {
+		if rate, err := p.BitRate(); err == nil && rate > 0 {
+			if t := 4 * time.Duration(bits) * time.Second / time.Duration(rate); t > wait {
+				wait = t
+			}
+		}
+	}
+	time.Sleep(wait)
+
+	after, err := p.OutputWaiting()
+	if err != nil {
+		return false, err
+	}
+
+	return after >= before, nil
+}
+
 // Set the flow control type in a port configuration. The port must be
 // opened for this operation. Call p.ApplyConfig() to apply the
 // change.
-func (p *Port) SetFlowControl(fc int) error {
+func (p *Port) SetFlowControl(fc FlowControl) error {
 	cfc, err := flow2c(fc)
 	if err != nil {
 		return err
 	}
 
-	if err := errmsg(C.sp_set_config_flowcontrol(p.c, cfc)); err != nil {
+	p.mu.Lock()
+	ret, errno := C.sp_set_config_flowcontrol(p.c, cfc)
+	err = errmsg("set_config_flowcontrol", ret, errno)
+	p.mu.Unlock()
+	if err != nil {
 		return err
 	}
 
 	return p.getConf()
 }
 
-func flow2c(fc int) (cfc C.enum_sp_flowcontrol, err error) {
+// Set the input buffer levels, in bytes, at which software flow
+// control sends XON (low) and XOFF (high).
+//
+// This is not supported on any current platform: it returns
+// ErrInvalidArguments for invalid arguments and ErrUnsupportedOperation
+// otherwise, and never changes the thresholds. The Linux tty layer uses
+// fixed thresholds, and libserialport does not expose the Windows
+// limits. It is reserved for platforms that can set them.
+func (p *Port) SetFlowThresholds(low, high int) error {
+	if low < 0 || high <= low {
+		return ErrInvalidArguments
+	}
+	return ErrUnsupportedOperation
+}
+
+// Get the flow control in effect on the port, as read back from the
+// live port configuration. This reports what the driver actually
+// accepted, which may differ from what was requested. Returns
+// FLOWCONTROL_INVALID if the pins are configured in a combination that
+// does not correspond to a standard flow control setting. The port
+// must be opened for this operation.
+func (p *Port) EffectiveFlowControl() (FlowControl, error) {
+	d, err := p.FlowControlDetail()
+	if err != nil {
+		return FLOWCONTROL_INVALID, err
+	}
+	return pins2flow(d.RTS, d.CTS, d.DTR, d.DSR, d.XonXoff), nil
+}
+
+// Flow control pin and XON/XOFF behaviours.
+type FlowDetail struct {
+	RTS     RTSMode
+	CTS     CTSMode
+	DTR     DTRMode
+	DSR     DSRMode
+	XonXoff XonXoffMode
+}
+
+// Get the flow control pin and XON/XOFF behaviours, read back from the
+// live port configuration in a single pass so that they are
+// consistent. The port must be opened for this operation.
+func (p *Port) FlowControlDetail() (FlowDetail, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.loadConf(); err != nil {
+		return FlowDetail{}, err
+	}
+	return p.flowDetail()
+}
+
+// Get the flow control pin and XON/XOFF behaviours from the local
+// configuration. Must be called with mu held.
+func (p *Port) flowDetail() (d FlowDetail, err error) {
+	rts := C.enum_sp_rts(C.SP_RTS_INVALID)
+	ret, errno := C.sp_get_config_rts(p.c, &rts)
+	if err = errmsg("get_config_rts", ret, errno); err != nil {
+		return
+	}
+	cts := C.enum_sp_cts(C.SP_CTS_INVALID)
+	ret, errno = C.sp_get_config_cts(p.c, &cts)
+	if err = errmsg("get_config_cts", ret, errno); err != nil {
+		return
+	}
+	dtr := C.enum_sp_dtr(C.SP_DTR_INVALID)
+	ret, errno = C.sp_get_config_dtr(p.c, &dtr)
+	if err = errmsg("get_config_dtr", ret, errno); err != nil {
+		return
+	}
+	dsr := C.enum_sp_dsr(C.SP_DSR_INVALID)
+	ret, errno = C.sp_get_config_dsr(p.c, &dsr)
+	if err = errmsg("get_config_dsr", ret, errno); err != nil {
+		return
+	}
+	xon := C.enum_sp_xonxoff(C.SP_XONXOFF_INVALID)
+	ret, errno = C.sp_get_config_xon_xoff(p.c, &xon)
+	if err = errmsg("get_config_xon_xoff", ret, errno); err != nil {
+		return
+	}
+
+	d.RTS, d.CTS, d.DTR, d.DSR = c2rts(rts), c2cts(cts), c2dtr(dtr), c2dsr(dsr)
+	d.XonXoff = c2xon(xon)
+	return
+}
+
+func pins2flow(rts RTSMode, cts CTSMode, dtr DTRMode, dsr DSRMode, xon XonXoffMode) FlowControl {
+	rtscts := rts == RTS_FLOW_CONTROL || cts == CTS_FLOW_CONTROL
+	dtrdsr := dtr == DTR_FLOW_CONTROL || dsr == DSR_FLOW_CONTROL
+
+	switch {
+	case xon == XONXOFF_DISABLED && !rtscts && !dtrdsr:
+		return FLOWCONTROL_NONE
+	case xon == XONXOFF_INOUT && !rtscts && !dtrdsr:
+		return FLOWCONTROL_XONXOFF
+	case xon == XONXOFF_DISABLED && rts == RTS_FLOW_CONTROL &&
+		cts == CTS_FLOW_CONTROL && !dtrdsr:
+		return FLOWCONTROL_RTSCTS
+	case xon == XONXOFF_DISABLED && dtr == DTR_FLOW_CONTROL &&
+		dsr == DSR_FLOW_CONTROL && !rtscts:
+		return FLOWCONTROL_DTRDSR
+	default:
+		return FLOWCONTROL_INVALID
+	}
+}
+
+func flow2c(fc FlowControl) (cfc C.enum_sp_flowcontrol, err error) {
 	switch fc {
 	case FLOWCONTROL_NONE:
 		cfc = C.SP_FLOWCONTROL_NONE
@@ -984,90 +2315,598 @@ func flow2c(fc int) (cfc C.enum_sp_flowcontrol, err error) {
 	return
 }
 
-// Implementation of io.Reader interface.
-func (p *Port) Read(b []byte) (int, error) {
-	var c int32
-	var start time.Time
+// Set the read mode. In READ_FILL_BUFFER mode, the default, Read
+// blocks until the buffer is full or the read deadline expires; a
+// short read returns the bytes received with ErrTimeout. In
+// READ_FIRST_BYTE mode, Read blocks until at least one byte arrives or
+// the read deadline expires, then returns the bytes waiting without
+// blocking further; ErrTimeout is returned only when no bytes arrive
+// before the deadline.
+func (p *Port) SetReadMode(mode int) error {
+	switch mode {
+	case READ_FILL_BUFFER, READ_FIRST_BYTE:
+		p.mu.Lock()
+		p.readMode = mode
+		p.mu.Unlock()
+		return nil
+	}
+	return ErrInvalidArguments
+}
+
+// Set the minimum time between the start of successive calls to Read
+// and ReadTimeout, for devices that misbehave when polled too quickly.
+// A call made too soon sleeps until the interval has elapsed; the sleep
+// is not bounded by the deadline. Zero disables the limit.
+func (p *Port) SetMinReadInterval(d time.Duration) error {
+	if d < 0 {
+		return ErrInvalidArguments
+	}
+	p.mu.Lock()
+	p.readInterval = d
+	p.mu.Unlock()
+	return nil
+}
 
-	if Debug {
-		start = time.Now()
+// Sleep until the minimum read interval has elapsed since the last
+// read, returning the read mode to use.
+func (p *Port) paceRead() int {
+	p.mu.Lock()
+	mode, wait := p.readMode, time.Duration(0)
+	if p.readInterval > 0 {
+		now := time.Now()
+		if wait = p.lastRead.Add(p.readInterval).Sub(now); wait < 0 {
+			wait = 0
+		}
+		p.lastRead = now.Add(wait)
+	}
+	p.mu.Unlock()
+
+	time.Sleep(wait)
+	return mode
+}
+
+// Set a kernel read policy, for control over read blocking that does
+// not depend on the deadline model. A read returns once minBytes bytes
+// have arrived, or once interByte has elapsed after the last byte
+// received; with minBytes of zero, interByte is an overall timeout, and
+// with both zero a read returns immediately. minBytes is limited to 255
+// and interByte must be a multiple of 100ms up to 25.5s, as set in the
+// VMIN and VTIME terminal attributes.
+//
+// While a read policy is set, reads ignore deadlines, the default
+// timeout and the read mode; the policy and deadlines should not be
+// mixed. The port stays in non-blocking mode, so writes are not
+// affected, and the policy is emulated by polling for input. A read
+// that returns no data reports ErrTimeout. Use ClearReadPolicy to
+// return to deadlines. Returns ErrUnsupportedOperation on platforms
+// without termios support; only Linux is currently supported.
+func (p *Port) SetReadPolicy(minBytes int, interByte time.Duration) error {
+	vtime := interByte / (100 * time.Millisecond)
+	if minBytes < 0 || minBytes > 255 || interByte < 0 || vtime > 255 ||
+		interByte%(100*time.Millisecond) != 0 {
+		return ErrInvalidArguments
+	}
+	if err := p.setReadPolicy(minBytes, int(vtime)); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.policy, p.vmin, p.vtime = true, minBytes, int(vtime)
+	p.mu.Unlock()
+	return nil
+}
+
+// Get the kernel read policy set with SetReadPolicy.
+func (p *Port) ReadPolicy() (minBytes int, interByte time.Duration, err error) {
+	vmin, vtime, err := p.readPolicy()
+	if err != nil {
+		return 0, 0, err
 	}
+	return vmin, time.Duration(vtime) * 100 * time.Millisecond, nil
+}
 
-	buf, size := unsafe.Pointer(&b[0]), C.size_t(len(b))
+// Clear the kernel read policy, so that reads use deadlines again.
+func (p *Port) ClearReadPolicy() error {
+	p.mu.Lock()
+	policy := p.policy
+	p.mu.Unlock()
 
-	if p.readDeadline.IsZero() {
+	if !policy {
+		return nil
+	}
+	if err := p.setReadPolicy(0, 0); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.policy = false
+	p.mu.Unlock()
+	return nil
+}
 
-		// no deadline
-		c = C.sp_blocking_read(p.p, buf, size, 0)
+// Set whether a parity or framing error received fails the read with
+// ErrParity, so that the caller can resync, rather than passing the
+// byte through. When enabled, parity checking is turned on and errors
+// are marked in the input by the driver; the data read before the
+// error is returned with ErrParity, and the byte in error and the rest
+// of the data received with it are discarded. A break is reported as
+// an error. Disabling turns parity checking off, which also resets the
+// break handling to BREAK_NUL if it was BREAK_MARK. Returns
+// ErrUnsupportedOperation on platforms without termios support; only
+// Linux is currently supported.
+func (p *Port) SetAbortOnParityError(enable bool) error {
+	p.mu.Lock()
+	adaptive := p.adaptive != nil
+	p.mu.Unlock()
+
+	if err := p.setParityCheck(enable || adaptive); err != nil {
+		return err
+	}
 
-	} else if millis := deadline2millis(p.readDeadline); millis <= 0 {
+	p.mu.Lock()
+	p.parityAbort = enable
+	p.markState = 0
+	p.mu.Unlock()
+	return nil
+}
 
-		// call nonblocking read
-		c = C.sp_nonblocking_read(p.p, buf, size)
+// Remove the parity error marks from the input in place, returning the
+// number of bytes of data kept and the number of errors marked. A mark
+// is 0xFF 0x00 followed by the byte in error, which is dropped, and a
+// 0xFF data byte is received as 0xFF 0xFF. If stop is set, decoding
+// stops at the first error and only the data before it is kept. Marks
+// may be split across reads. Must be called with mu held.
+func (p *Port) decodeParity(b []byte, stop bool) (int, int) {
+	return decodeMarks(b, &p.markState, stop)
+}
+
+// Remove the parity error marks from the input in place, as for
+// decodeParity, with the state of a mark split across reads kept in
+// state.
+func decodeMarks(b []byte, state *int, stop bool) (int, int) {
+	n, errs := 0, 0
+	for _, c := range b {
+		switch *state {
+		case 0:
+			if c == 0xFF {
+				*state = 1
+				continue
+			}
+		case 1:
+			if c == 0x00 {
+				*state = 2
+				continue
+			}
+			*state = 0
+		case 2:
+			*state = 0
+			if errs++; stop {
+				return n, errs
+			}
+			continue
+		}
+		b[n] = c
+		n++
+	}
+	return n, errs
+}
 
-	} else {
+// Implementation of io.Reader interface. Returns io.EOF once the
+// device has hung up, such as when the other end of a pseudo-terminal
+// is closed, so that io.Copy loops terminate. When the read deadline
+// expires, the bytes received are returned with ErrTimeout, which
+// reports true from Timeout() as a net.Error and, as for a net.Conn,
+// matches os.ErrDeadlineExceeded with errors.Is.
+func (p *Port) Read(b []byte) (int, error) {
+	mode := p.paceRead()
+	return p.read(b, p.ReadDeadline(), mode)
+}
+
+func (p *Port) read(b []byte, deadline time.Time, mode int) (int, error) {
+	if p.mode&MODE_READ == 0 {
+		return 0, ErrNotReadable
+	} else if len(b) == 0 {
+		return 0, nil
+	}
+
+	deadline = p.deadline(deadline)
+
+	// take buffered input first
+	n := p.takeBuffered(b)
+	if n == len(b) || (n > 0 && mode == READ_FIRST_BYTE) {
+		return n, nil
+	}
+
+	c, err := p.readPort(b[n:], deadline, mode)
+	return n + c, err
+}
+
+// Read from the port, bypassing buffered input. The echo of written
+// data is removed when echo cancellation is enabled.
+func (p *Port) readPort(b []byte, deadline time.Time, mode int) (int, error) {
+	p.mu.Lock()
+	policy, abort, adaptive := p.policy, p.parityAbort, p.adaptive
+	p.mu.Unlock()
+
+	n := 0
+	for {
+		c, err := p.readDevice(b[n:], deadline, mode, policy)
+		if abort || adaptive != nil {
+			var errs int
+			p.mu.Lock()
+			c, errs = p.decodeParity(b[n:n+c], abort)
+			p.mu.Unlock()
+			if errs > 0 && adaptive != nil {
+				if aerr := adaptive.count(p, errs); aerr != nil && err == nil {
+					err = aerr
+				}
+			}
+			if errs > 0 && abort {
+				err = ErrParity
+			}
+		}
+		p.capture.record(CAPTURE_RX, b[n:n+c])
+		c = p.stripEcho(b[n : n+c])
+		n += c
+		if err != nil || n == len(b) || (n > 0 && mode == READ_FIRST_BYTE) || policy {
+			return n, err
+		}
+	}
+}
 
-		// call blocking read
-		c = C.sp_blocking_read(p.p, buf, size, C.uint(millis))
+// Read from the device, with a single call into the kernel if a read
+// policy is set.
+func (p *Port) readDevice(b []byte, deadline time.Time, mode int, policy bool) (int, error) {
+	var n int
+	var err error
+	var start time.Time
 
+	if policy {
+		return p.policyRead(b)
 	}
 
 	if Debug {
-		log.Printf("read time: %d ns", time.Since(start).Nanoseconds())
+		start = time.Now()
 	}
 
-	n := int(c)
+	if mode == READ_FIRST_BYTE {
+
+		// wait for the first byte, then take whatever else is waiting;
+		// the byte read is returned if that fails, and the failure is
+		// left for the next read to report
+		n, err = p.blockingRead(b[:1], deadline)
+		if n == 1 && len(b) > 1 {
+			if c, err := p.readOnce(b[1:], 0); err == nil {
+				n += c
+			}
+		}
+
+	} else {
+
+		// wait for the buffer to fill
+		n, err = p.blockingRead(b, deadline)
 
-	// check for error
-	if n < 0 {
-		return 0, errmsg(c)
-	} else if n != len(b) {
-		return n, ErrTimeout
 	}
 
-	// update slice length
-	reflect.ValueOf(&b).Elem().SetLen(int(c))
+	if Debug {
+		log.Printf("read time: %d ns", time.Since(start).Nanoseconds())
+	}
+
+	// check for error
+	if err != nil {
+		return n, err
+	} else if n == 0 || (mode != READ_FIRST_BYTE && n != len(b)) {
+		return n, ErrTimeout
+	}
 
 	return n, nil
 }
 
-// Implementation of io.Writer interface.
-func (p *Port) Write(b []byte) (int, error) {
-	var c int32
-	var start time.Time
+// Read into the buffer until it is full or the deadline expires. The
+// read is made in calls of at most ioSlice, so that Close and
+// configuration changes are not held off, and ends with ErrNotOpen if
+// the port is closed meanwhile.
+func (p *Port) blockingRead(b []byte, deadline time.Time) (int, error) {
+	n := 0
+	for {
+		c, err := p.readOnce(b[n:], ioMillis(deadline))
+		n += c
+		if err != nil || n == len(b) || expired(deadline) {
+			return n, err
+		}
+	}
+}
 
-	if Debug {
-		start = time.Now()
+// Make a single read, waiting up to millis for the buffer to fill, or
+// without blocking if millis is zero.
+func (p *Port) readOnce(b []byte, millis int64) (int, error) {
+	if err := p.beginIO(); err != nil {
+		return 0, err
 	}
+	defer p.iomu.RUnlock()
+
+	var c C.enum_sp_return
+	var errno error
 
 	buf, size := unsafe.Pointer(&b[0]), C.size_t(len(b))
 
-	if p.writeDeadline.IsZero() {
+	if millis <= 0 {
+		c, errno = C.sp_nonblocking_read(p.p, buf, size)
+	} else {
+		c, errno = C.sp_blocking_read(p.p, buf, size, C.uint(millis))
+	}
+
+	if c < 0 {
+		return 0, p.readErr(int32(c), errno)
+	}
+	return int(c), nil
+}
 
-		// no deadline
-		c = C.sp_blocking_write(p.p, buf, size, 0)
+// Map a read error to an error. A read that fails with EIO, as when
+// the other end of a pseudo-terminal is closed, or that finds the end
+// of file on a device that has hung up, is reported as io.EOF. A read
+// that fails because no data is available is reported as ErrTimeout,
+// as is a read that returns no data, so that "nothing yet" can be told
+// apart from a failure. Other failures are reported as an OpError.
+func (p *Port) readErr(c int32, errno error) error {
+	if c == C.SP_ERR_FAIL {
+		switch errno {
+		case syscall.EIO:
+			return io.EOF
+		case syscall.EAGAIN:
+			return ErrTimeout
+		case nil:
+			// libserialport reports the end of file as a failure
+			// without setting errno
+			if p.hungUp() {
+				return io.EOF
+			}
+		}
+	}
+	return errmsg("read", C.enum_sp_return(c), errno)
+}
+
+// Implementation of io.RuneReader interface. Reads a single UTF-8
+// encoded character, subject to the read deadline. The bytes of a
+// character split across reads are buffered until the character is
+// complete, including across calls that time out. An invalid encoding
+// is returned as utf8.RuneError with a size of 1.
+func (p *Port) ReadRune() (r rune, size int, err error) {
+	for {
+		p.mu.Lock()
+		if utf8.FullRune(p.rbuf) {
+			r, size = utf8.DecodeRune(p.rbuf)
+			p.rbuf = p.rbuf[size:]
+			p.mu.Unlock()
+			return r, size, nil
+		}
+		p.mu.Unlock()
 
-	} else if millis := deadline2millis(p.writeDeadline); millis <= 0 {
+		if err := p.fill(p.ReadDeadline()); err != nil {
+			return 0, 0, err
+		}
+	}
+}
 
-		// call nonblocking write
-		c = C.sp_nonblocking_write(p.p, buf, size)
+// Read at least one byte into the input buffer, reading ahead as much
+// data as is waiting, up to the buffer size hint.
+func (p *Port) fill(deadline time.Time) error {
+	if p.mode&MODE_READ == 0 {
+		return ErrNotReadable
+	}
 
-	} else {
+	size := p.bufSize
+	if size < utf8.UTFMax {
+		size = utf8.UTFMax
+	}
+
+	// read into the space after the buffered input, which only the
+	// reader touches, and append what was read under the lock in case
+	// the input was discarded meanwhile
+	p.mu.Lock()
+	n := len(p.rbuf)
+	if cap(p.rbuf)-n < size {
+		rbuf := make([]byte, n, n+size)
+		copy(rbuf, p.rbuf)
+		p.rbuf = rbuf
+	}
+	buf := p.rbuf[n : n+size]
+	p.mu.Unlock()
+
+	c, err := p.readPort(buf, p.deadline(deadline), READ_FIRST_BYTE)
+
+	p.mu.Lock()
+	p.rbuf = append(p.rbuf, buf[:c]...)
+	p.mu.Unlock()
+
+	return err
+}
+
+// Take buffered input into b, returning the number of bytes taken.
+func (p *Port) takeBuffered(b []byte) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := copy(b, p.rbuf)
+	p.rbuf = p.rbuf[n:]
+	return n
+}
+
+// Take the next byte of buffered input, if any.
+func (p *Port) nextBuffered() (byte, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.rbuf) == 0 {
+		return 0, false
+	}
+	c := p.rbuf[0]
+	p.rbuf = p.rbuf[1:]
+	return c, true
+}
+
+// Get the number of bytes of buffered input.
+func (p *Port) buffered() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.rbuf)
+}
+
+// Discard buffered input.
+func (p *Port) dropBuffered() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rbuf = nil
+}
+
+// Read with a timeout for this call only, leaving the read deadline
+// unchanged. A timeout of zero or less performs a nonblocking read.
+func (p *Port) ReadTimeout(b []byte, d time.Duration) (int, error) {
+	mode := p.paceRead()
+	return p.read(b, time.Now().Add(d), mode)
+}
+
+// Read the data that is already buffered, without waiting for more,
+// in a single operation. This avoids sizing a read with InputWaiting
+// and then reading more or less than was counted because data arrived
+// in between. Returns an empty slice if no data is waiting.
+func (p *Port) ReadAvailable() ([]byte, error) {
+	if p.mode&MODE_READ == 0 {
+		return nil, ErrNotReadable
+	}
+
+	n, err := p.InputWaiting()
+	if err != nil {
+		return nil, err
+	}
+	n += p.buffered()
+	if n == 0 {
+		return []byte{}, nil
+	}
+
+	buf := make([]byte, n)
+	c, err := p.read(buf, time.Now(), READ_FIRST_BYTE)
+	if err == ErrTimeout {
+		err = nil
+	}
+
+	return buf[:c], err
+}
+
+// Set the maximum number of bytes that reads accumulating data until a
+// delimiter, such as ReadLine, will buffer before giving up with
+// ErrTooLong, to protect against a device that never sends the
+// delimiter. Zero removes the limit.
+func (p *Port) SetMaxReadSize(n int) error {
+	if n < 0 {
+		return ErrInvalidArguments
+	}
+	p.mu.Lock()
+	p.maxRead = n
+	p.mu.Unlock()
+	return nil
+}
+
+// Get the maximum read size set with SetMaxReadSize.
+func (p *Port) maxReadSize() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.maxRead
+}
+
+// Read until the data read ends with delim or the deadline expires.
+// Returns ErrTooLong once the maximum read size is reached.
+func (p *Port) readUntil(delim []byte, deadline time.Time) ([]byte, error) {
+	var buf []byte
+
+	if len(delim) == 0 {
+		return buf, nil
+	}
+
+	max := p.maxReadSize()
+	for {
+		for p.buffered() > 0 {
+			if max > 0 && len(buf) >= max {
+				return buf, ErrTooLong
+			}
+			c, ok := p.nextBuffered()
+			if !ok {
+				break
+			}
+			buf = append(buf, c)
+			if bytes.HasSuffix(buf, delim) {
+				return buf, nil
+			}
+		}
+		if err := p.fill(deadline); err != nil {
+			return buf, err
+		}
+	}
+}
+
+// Implementation of io.Writer interface.
+func (p *Port) Write(b []byte) (int, error) {
+	return p.write(b, p.WriteDeadline())
+}
+
+// Write all of the data, subject to the write deadline, retrying after
+// short writes until the data is written or the deadline expires. The
+// deadline, or the default timeout, covers the whole call rather than
+// each write. Returns the number of bytes written with ErrTimeout if
+// the deadline expires first.
+func (p *Port) WriteFull(b []byte) (int, error) {
+	deadline := p.deadline(p.WriteDeadline())
+
+	n := 0
+	for n < len(b) {
+		c, err := p.write(b[n:], deadline)
+		n += c
+		if err == ErrTimeout && (deadline.IsZero() || time.Now().Before(deadline)) {
+			continue
+		} else if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+func (p *Port) write(b []byte, deadline time.Time) (int, error) {
+	var n int
+	var err error
+	var start time.Time
+
+	if p.mode&MODE_WRITE == 0 {
+		return 0, ErrNotWritable
+	} else if len(b) == 0 {
+		return 0, nil
+	}
 
-		// call blocking write
-		c = C.sp_blocking_write(p.p, buf, size, C.uint(millis))
+	deadline = p.deadline(deadline)
 
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+
+	if Debug {
+		start = time.Now()
+	}
+
+	p.mu.Lock()
+	delay := p.txDelay
+	p.mu.Unlock()
+
+	if delay > 0 {
+		n, err = p.spacedWrite(b, deadline, delay)
+	} else {
+		n, err = p.blockingWrite(b, deadline)
 	}
 
 	if Debug {
 		log.Printf("write time: %d ns", time.Since(start).Nanoseconds())
 	}
 
-	n := int(c)
+	p.capture.record(CAPTURE_TX, b[:n])
+	p.expectEcho(b[:n])
 
 	// check for error
-	if n < 0 {
-		return 0, errmsg(c)
+	if err != nil {
+		return n, err
 	} else if n != len(b) {
 		return n, ErrTimeout
 	}
@@ -1075,12 +2914,262 @@ func (p *Port) Write(b []byte) (int, error) {
 	return n, nil
 }
 
+// Write the buffer until it is written or the deadline expires. The
+// write is made in calls of at most ioSlice, so that Close and
+// configuration changes are not held off, and ends with ErrNotOpen if
+// the port is closed meanwhile.
+func (p *Port) blockingWrite(b []byte, deadline time.Time) (int, error) {
+	n := 0
+	for {
+		c, err := p.writeOnce(b[n:], ioMillis(deadline))
+		n += c
+		if err != nil || n == len(b) || expired(deadline) {
+			return n, err
+		}
+	}
+}
+
+// Make a single write, waiting up to millis for the buffer to be
+// written, or without blocking if millis is zero.
+func (p *Port) writeOnce(b []byte, millis int64) (int, error) {
+	if err := p.beginIO(); err != nil {
+		return 0, err
+	}
+	defer p.iomu.RUnlock()
+
+	var c C.enum_sp_return
+	var errno error
+
+	buf, size := unsafe.Pointer(&b[0]), C.size_t(len(b))
+
+	if millis <= 0 {
+		c, errno = C.sp_nonblocking_write(p.p, buf, size)
+	} else {
+		c, errno = C.sp_blocking_write(p.p, buf, size, C.uint(millis))
+	}
+
+	if c < 0 {
+		return 0, errmsg("write", c, errno)
+	}
+	return int(c), nil
+}
+
+// Write the buffer a byte at a time, waiting for each byte to be
+// transmitted and then for the transmit byte delay.
+func (p *Port) spacedWrite(b []byte, deadline time.Time, delay time.Duration) (int, error) {
+	n := 0
+	for i := range b {
+		if i > 0 {
+			time.Sleep(delay)
+		}
+		c, err := p.blockingWrite(b[i:i+1], deadline)
+		if err != nil || c == 0 {
+			return n, err
+		}
+		n++
+		if err := p.drainOnce(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Wait for the output buffer to be transmitted, holding off Close.
+func (p *Port) drainOnce() error {
+	if err := p.beginIO(); err != nil {
+		return err
+	}
+	defer p.iomu.RUnlock()
+
+	ret, errno := C.sp_drain(p.p)
+	return errmsg("drain", ret, errno)
+}
+
+// Set a delay between transmitted bytes, for devices with small input
+// buffers. When set, writes send one byte at a time, waiting for each
+// byte to be transmitted and then for the delay, so that a write takes
+// at least the delay times one less than its length. Zero disables the
+// delay. TryWrite is not affected.
+func (p *Port) SetTxByteDelay(d time.Duration) error {
+	if d < 0 {
+		return ErrInvalidArguments
+	}
+	p.mu.Lock()
+	p.txDelay = d
+	p.mu.Unlock()
+	return nil
+}
+
+// Write as much of the buffer as fits in the output buffer without
+// blocking. Returns the number of bytes written; zero with a nil error
+// means the output buffer is full and the caller should retry later.
+func (p *Port) TryWrite(b []byte) (int, error) {
+	if p.mode&MODE_WRITE == 0 {
+		return 0, ErrNotWritable
+	} else if len(b) == 0 {
+		return 0, nil
+	}
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	c, err := p.writeOnce(b, 0)
+	if err != nil {
+		return 0, err
+	}
+	p.capture.record(CAPTURE_TX, b[:c])
+	p.expectEcho(b[:c])
+	return c, nil
+}
+
+// Write data every interval in a background goroutine, for devices
+// that drop the link when it is idle, until stop is called or the port
+// is closed. Each write is serialized with other writes to the port
+// and gives up if it cannot complete within interval. Write errors are
+// ignored. A non-positive interval sends nothing.
+func (p *Port) StartKeepalive(data []byte, interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+
+	data = append([]byte(nil), data...)
+	quit := make(chan struct{})
+	exited := make(chan struct{})
+
+	p.spawn(func(done <-chan struct{}) {
+		defer close(exited)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				p.write(data, time.Now().Add(interval))
+			case <-quit:
+				return
+			case <-done:
+				return
+			}
+		}
+	})
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(quit)
+		})
+		<-exited
+	}
+}
+
+// Enable or disable echo cancellation, for devices that echo the data
+// written to them. When enabled, data read from the port that matches
+// data previously written is removed from the input, on a best-effort
+// basis: input that does not match the expected echo is passed through
+// and the echo is assumed lost. At most echoLimit bytes of written data
+// are remembered.
+func (p *Port) SetEchoCancel(enable bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.echoCancel = enable
+	p.echo = nil
+}
+
+// Remember written data whose echo should be removed from the input.
+func (p *Port) expectEcho(b []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.echoCancel {
+		return
+	}
+	p.echo = append(p.echo, b...)
+	if len(p.echo) > echoLimit {
+		p.echo = p.echo[len(p.echo)-echoLimit:]
+	}
+}
+
+// Remove the expected echo from the start of the input, returning the
+// number of bytes that remain.
+func (p *Port) stripEcho(b []byte) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.echoCancel {
+		return len(b)
+	}
+
+	n := 0
+	for n < len(b) && n < len(p.echo) && b[n] == p.echo[n] {
+		n++
+	}
+
+	if n < len(b) && n < len(p.echo) {
+		p.echo = nil
+	} else {
+		p.echo = p.echo[n:]
+	}
+
+	return copy(b, b[n:])
+}
+
+// Write with a timeout for this call only, leaving the write deadline
+// unchanged. A timeout of zero or less performs a nonblocking write.
+func (p *Port) WriteTimeout(b []byte, d time.Duration) (int, error) {
+	return p.write(b, time.Now().Add(d))
+}
+
 // WriteString is like Write, but writes the contents of string s
 // rather than a slice of bytes.
 func (p *Port) WriteString(s string) (int, error) {
 	return p.Write(bytes.NewBufferString(s).Bytes())
 }
 
+// Set the line terminator used by ReadLine and WriteLine. The default
+// is "\r\n".
+func (p *Port) SetLineTerminator(term []byte) error {
+	if len(term) == 0 {
+		return ErrInvalidArguments
+	}
+	p.mu.Lock()
+	p.lineTerm = append([]byte(nil), term...)
+	p.mu.Unlock()
+	return nil
+}
+
+// Get the line terminator, or the default if none is set.
+func (p *Port) lineTerminator() []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.lineTerm == nil {
+		return []byte("\r\n")
+	}
+	return p.lineTerm
+}
+
+// Write s followed by the line terminator in a single write.
+func (p *Port) WriteLine(s string) error {
+	term := p.lineTerminator()
+	b := make([]byte, 0, len(s)+len(term))
+	b = append(append(b, s...), term...)
+	_, err := p.write(b, p.WriteDeadline())
+	return err
+}
+
+// Read a line ending with the line terminator, within the read
+// deadline. The line is returned without the terminator. On error, the
+// partial line read so far is returned.
+func (p *Port) ReadLine() (string, error) {
+	if p.mode&MODE_READ == 0 {
+		return "", ErrNotReadable
+	}
+	term := p.lineTerminator()
+	b, err := p.readUntil(term, p.ReadDeadline())
+	if err != nil {
+		return string(b), err
+	}
+	return string(b[:len(b)-len(term)]), nil
+}
+
 // Implementation of net.Conn.LocalAddr
 func (p *Port) LocalAddr() net.Addr {
 	return &Addr{name: p.Name()}
@@ -1091,8 +3180,32 @@ func (p *Port) RemoteAddr() net.Addr {
 	return &Addr{name: p.Name()}
 }
 
+// Set a timeout for reads and writes made without a deadline. Zero,
+// the default, lets such operations block indefinitely.
+func (p *Port) SetDefaultTimeout(d time.Duration) error {
+	if d < 0 {
+		return ErrInvalidArguments
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.timeout = d
+	return nil
+}
+
+// Apply the default timeout to a zero deadline.
+func (p *Port) deadline(t time.Time) time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t.IsZero() && p.timeout > 0 {
+		return time.Now().Add(p.timeout)
+	}
+	return t
+}
+
 // Implementation of net.Conn.SetDeadline
 func (p *Port) SetDeadline(t time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	p.readDeadline = t
 	p.writeDeadline = t
 	return nil
@@ -1100,52 +3213,471 @@ func (p *Port) SetDeadline(t time.Time) error {
 
 // Implementation of net.Conn.SetReadDeadline
 func (p *Port) SetReadDeadline(t time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	p.readDeadline = t
 	return nil
 }
 
 // Implementation of net.Conn.SetWriteDeadline
 func (p *Port) SetWriteDeadline(t time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	p.writeDeadline = t
 	return nil
 }
 
+// Get the read deadline. The zero time means no deadline.
+func (p *Port) ReadDeadline() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.readDeadline
+}
+
+// Get the write deadline. The zero time means no deadline.
+func (p *Port) WriteDeadline() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.writeDeadline
+}
+
 // Gets the number of bytes waiting in the input buffer.
 func (p *Port) InputWaiting() (int, error) {
-	c := C.sp_input_waiting(p.p)
+	c, errno := C.sp_input_waiting(p.p)
 	if c < 0 {
-		return 0, errmsg(c)
+		return 0, errmsg("input_waiting", c, errno)
 	}
 	return int(c), nil
 }
 
 // Gets the number of bytes waiting in the output buffer.
 func (p *Port) OutputWaiting() (int, error) {
-	c := C.sp_output_waiting(p.p)
+	c, errno := C.sp_output_waiting(p.p)
 	if c < 0 {
-		return 0, errmsg(c)
+		return 0, errmsg("output_waiting", c, errno)
 	}
 	return int(c), nil
 }
 
-// Wait for buffered data to be transmitted.
+// Wait for buffered data to be transmitted. Returns ErrNotDrained if
+// the driver reports bytes still waiting in the output buffer after
+// the drain completes.
 func (p *Port) Sync() error {
-	return errmsg(C.sp_drain(p.p))
+	ret, errno := C.sp_drain(p.p)
+	if err := errmsg("drain", ret, errno); err != nil {
+		return err
+	}
+
+	if n, err := p.OutputWaiting(); err != nil {
+		return err
+	} else if n != 0 {
+		return ErrNotDrained
+	}
+
+	return nil
+}
+
+// Wait until the output buffer of the driver is empty, polling the
+// number of bytes waiting every millisecond. Unlike Sync, this does not
+// wait for the UART to shift out the bytes already in its FIFO, so it
+// returns as soon as the last byte leaves the driver; call Sync
+// afterwards to wait for the line to go idle, such as before releasing
+// an RS-485 transmitter. Returns ErrTimeout if data is still waiting
+// after timeout; a timeout of zero waits indefinitely.
+func (p *Port) WaitOutputEmpty(timeout time.Duration) error {
+	deadline := timeoutDeadline(timeout)
+
+	for {
+		n, err := p.OutputWaiting()
+		if err != nil {
+			return err
+		} else if n == 0 {
+			return nil
+		}
+
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return ErrTimeout
+		}
+
+		time.Sleep(time.Millisecond)
+	}
 }
 
 // Discard buffered data.
 func (p *Port) Reset() error {
-	return errmsg(C.sp_flush(p.p, C.SP_BUF_BOTH))
+	p.dropBuffered()
+	ret, errno := C.sp_flush(p.p, C.SP_BUF_BOTH)
+	return errmsg("flush", ret, errno)
 }
 
 // Discard buffered input data.
 func (p *Port) ResetInput() error {
-	return errmsg(C.sp_flush(p.p, C.SP_BUF_INPUT))
+	p.dropBuffered()
+	ret, errno := C.sp_flush(p.p, C.SP_BUF_INPUT)
+	return errmsg("flush", ret, errno)
 }
 
 // Discard buffered output data.
 func (p *Port) ResetOutput() error {
-	return errmsg(C.sp_flush(p.p, C.SP_BUF_OUTPUT))
+	ret, errno := C.sp_flush(p.p, C.SP_BUF_OUTPUT)
+	return errmsg("flush", ret, errno)
+}
+
+// Discard buffered input data, returning the number of bytes
+// discarded. Bytes that arrive between counting and discarding are
+// discarded but not counted.
+func (p *Port) ResetInputN() (int, error) {
+	n, err := p.InputWaiting()
+	if err != nil {
+		return 0, err
+	}
+	n += p.buffered()
+	return n, p.ResetInput()
+}
+
+// Discard buffered output data, returning the number of bytes
+// discarded. Bytes that are transmitted between counting and
+// discarding are counted but not discarded.
+func (p *Port) ResetOutputN() (int, error) {
+	n, err := p.OutputWaiting()
+	if err != nil {
+		return 0, err
+	}
+	return n, p.ResetOutput()
+}
+
+// Wait until no data has arrived and the input signals have not
+// changed for the idle duration, then discard buffered data in both
+// directions. This leaves the port in a known state before a critical
+// exchange. Returns ErrTimeout if the line does not become idle before
+// the read deadline.
+func (p *Port) QuiesceAndFlush(idle time.Duration) error {
+	step := idle / 10
+	if step < time.Millisecond {
+		step = time.Millisecond
+	} else if step > 50*time.Millisecond {
+		step = 50 * time.Millisecond
+	}
+
+	waiting, err := p.InputWaiting()
+	if err != nil {
+		return err
+	}
+	sigs, err := p.signals()
+	if err != nil {
+		return err
+	}
+
+	deadline := p.deadline(p.ReadDeadline())
+	for since := time.Now(); time.Since(since) < idle; {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return ErrTimeout
+		}
+
+		time.Sleep(step)
+
+		w, err := p.InputWaiting()
+		if err != nil {
+			return err
+		}
+		s, err := p.signals()
+		if err != nil {
+			return err
+		}
+
+		if w != waiting || s != sigs {
+			waiting, sigs, since = w, s, time.Now()
+		}
+	}
+
+	return p.Reset()
+}
+
+// Start transmitting a break condition, holding the line in the
+// spacing state until EndBreak is called. Returns
+// ErrUnsupportedOperation if the driver does not support breaks.
+func (p *Port) StartBreak() error {
+	ret, errno := C.sp_start_break(p.p)
+	return errmsg("start_break", ret, errno)
+}
+
+// Stop transmitting a break condition.
+func (p *Port) EndBreak() error {
+	ret, errno := C.sp_end_break(p.p)
+	return errmsg("end_break", ret, errno)
+}
+
+// Transmit a break condition for the given duration. A zero duration
+// starts and immediately ends the break, which still holds the line for
+// at least one character time on most drivers. The duration is timed
+// with time.Sleep, so the break may last longer than requested by the
+// scheduling latency of the system, typically up to a few
+// milliseconds; do not rely on it for precise timing. The break is
+// ended even if the calling goroutine panics, and concurrent calls are
+// serialized so that one caller does not end another's break early.
+// Returns ErrNotOpen if the port is not open.
+func (p *Port) SendBreak(d time.Duration) (err error) {
+	if !p.isOpen() {
+		return ErrNotOpen
+	}
+
+	p.bmu.Lock()
+	defer p.bmu.Unlock()
+
+	if err = p.StartBreak(); err != nil {
+		return
+	}
+	defer func() {
+		if e := p.EndBreak(); err == nil {
+			err = e
+		}
+	}()
+
+	if d > 0 {
+		time.Sleep(d)
+	}
+
+	return
+}
+
+// Get the live state of the modem control input lines, as a
+// combination of the SIG_* values. Unlike CTS() and DSR(), which return
+// the configured pin behaviours, this reads the lines themselves.
+// Returns ErrNotOpen if the port is not open.
+func (p *Port) Signals() (int, error) {
+	if !p.isOpen() {
+		return 0, ErrNotOpen
+	}
+	return p.signals()
+}
+
+// Check whether the given input signal, one of the SIG_* values, is
+// asserted. Returns ErrNotOpen if the port is not open.
+func (p *Port) HasSignal(sig int) (bool, error) {
+	sigs, err := p.Signals()
+	if err != nil {
+		return false, err
+	}
+	return sigs&sig != 0, nil
+}
+
+// Get the state of the input signals.
+func (p *Port) signals() (int, error) {
+	var sigs C.enum_sp_signal
+	ret, errno := C.sp_get_signals(p.p, &sigs)
+	if err := errmsg("get_signals", ret, errno); err != nil {
+		return 0, err
+	}
+	return int(sigs), nil
+}
+
+// Wait for any of the events, a combination of the EVENT_* values, on
+// any of the ports, so that several ports can be serviced from one
+// goroutine. Returns the indices of the ports with events pending;
+// data already buffered by the port counts as received. A timeout of
+// zero waits forever; otherwise ErrTimeout is returned if no port is
+// ready in time.
+//
+// On Linux, EVENT_TX_READY is reported when there is room in the
+// output buffer. On other platforms it is reported only once the
+// output buffer is empty, and is detected by polling every 10ms, so
+// it may be reported up to that late.
+func Select(ports []*Port, events int, timeout time.Duration) (ready []int, err error) {
+	fired, err := waitEvents(ports, events, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, ev := range fired {
+		if ev != 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	return ready, nil
+}
+
+// Wait for any of the events, a combination of the EVENT_* values, on
+// any of the ports, returning the ports with events pending, in the
+// order given. Like Select, but returns the ports rather than their
+// indices. Returns ErrInvalidArguments if no ports are given. See
+// Select for how EVENT_TX_READY differs between platforms.
+func WaitAny(ports []*Port, events int, timeout time.Duration) ([]*Port, error) {
+	fired, err := waitEvents(ports, events, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	var ready []*Port
+	for i, ev := range fired {
+		if ev != 0 {
+			ready = append(ready, ports[i])
+		}
+	}
+
+	return ready, nil
+}
+
+// Wait for any of the events, a combination of the EVENT_* values, on
+// the port. Returns the events that are pending; data already buffered
+// by the port counts as received. A timeout of zero waits forever;
+// otherwise ErrTimeout is returned if no event occurs in time. See
+// Select for how EVENT_TX_READY differs between platforms.
+func (p *Port) WaitFor(events int, timeout time.Duration) (int, error) {
+	fired, err := waitEvents([]*Port{p}, events, timeout)
+	if err != nil {
+		return 0, err
+	}
+	return fired[0], nil
+}
+
+// Wait for any of the events on any of the ports, returning the events
+// pending on each port once at least one is ready.
+func waitEvents(ports []*Port, events int, timeout time.Duration) ([]int, error) {
+	if len(ports) == 0 || events == 0 ||
+		events&^(EVENT_RX_READY|EVENT_TX_READY|EVENT_ERROR) != 0 {
+		return nil, ErrInvalidArguments
+	}
+
+	var set *C.struct_sp_event_set
+	ret, errno := C.sp_new_event_set(&set)
+	if err := errmsg("new_event_set", ret, errno); err != nil {
+		return nil, err
+	}
+	defer C.sp_free_event_set(set)
+
+	mask, poll := waitMask(events)
+	if mask != 0 {
+		for _, p := range ports {
+			ret, errno := C.sp_add_port_events(set, p.p, C.enum_sp_event(mask))
+			if err := errmsg("add_port_events", ret, errno); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	deadline := timeoutDeadline(timeout)
+
+	fired := make([]int, len(ports))
+	for {
+		// check each port, since the wait does not report which are ready
+		ready := false
+		for i, p := range ports {
+			ev, err := p.pendingEvents(events)
+			if err != nil {
+				return nil, err
+			}
+			if events&EVENT_RX_READY != 0 && p.buffered() > 0 {
+				ev |= EVENT_RX_READY
+			}
+			if fired[i] = ev; ev != 0 {
+				ready = true
+			}
+		}
+		if ready {
+			return fired, nil
+		}
+
+		millis := int64(0)
+		if !deadline.IsZero() {
+			if millis = deadline2millis(deadline); millis <= 0 {
+				return nil, ErrTimeout
+			}
+		}
+
+		// wake up to poll for the events that are not waited for
+		if step := int64(poll / time.Millisecond); poll > 0 && (millis == 0 || millis > step) {
+			millis = step
+		}
+		if mask == 0 {
+			time.Sleep(time.Duration(millis) * time.Millisecond)
+			continue
+		}
+
+		ret, errno := C.sp_wait(set, C.uint(millis))
+		if err := errmsg("wait", ret, errno); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Check whether a device is attached to the port, as indicated by the
+// carrier detect or data set ready input signals. An open port with
+// nothing attached, or a device that drives neither signal, reports
+// false.
+func (p *Port) DeviceConnected() (bool, error) {
+	sigs, err := p.signals()
+	if err != nil {
+		return false, err
+	}
+	return sigs&(SIG_DCD|SIG_DSR) != 0, nil
+}
+
+// State of the input signals, as a combination of the SIG_* values.
+type SignalState int
+
+// Check whether the given input signal is asserted.
+func (s SignalState) Has(sig int) bool {
+	return int(s)&sig != 0
+}
+
+// Call fn in a background goroutine when the input signals change and
+// have been stable for the debounce interval, so that a chattering
+// line produces a single notification per real change. The signals are
+// polled at a tenth of the debounce interval, between 1ms and 50ms.
+// Notifications stop when stop is called or the port is closed; fn
+// must not call stop, but may close the port.
+func (p *Port) OnSignalChange(debounce time.Duration, fn func(SignalState)) (stop func()) {
+	step := debounce / 10
+	if step < time.Millisecond {
+		step = time.Millisecond
+	} else if step > 50*time.Millisecond {
+		step = 50 * time.Millisecond
+	}
+
+	quit := make(chan struct{})
+	exited := make(chan struct{})
+
+	p.spawn(func(done <-chan struct{}) {
+		defer close(exited)
+
+		ticker := time.NewTicker(step)
+		defer ticker.Stop()
+
+		reported, _ := p.signals()
+		last, since := reported, time.Now()
+		for {
+			select {
+			case <-ticker.C:
+			case <-quit:
+				return
+			case <-done:
+				return
+			}
+
+			sigs, err := p.signals()
+			if err != nil {
+				continue
+			}
+
+			if sigs != last {
+				last, since = sigs, time.Now()
+			} else if sigs != reported && time.Since(since) >= debounce {
+				reported = sigs
+				p.callback(func() {
+					fn(SignalState(sigs))
+				})
+			}
+		}
+	})
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(quit)
+		})
+		<-exited
+	}
 }
 
 // Implementation of net.Addr.Network()
@@ -1172,3 +3704,218 @@ func (e *Error) Timeout() bool {
 func (e *Error) Temporary() bool {
 	return e.temporary
 }
+
+// Report a timeout as os.ErrDeadlineExceeded to errors.Is, as net.Conn
+// does for an expired deadline.
+func (e *Error) Is(target error) bool {
+	return e.timeout && target == os.ErrDeadlineExceeded
+}
+
+// Implementation of error interface.
+func (e *OpError) Error() string {
+	return e.Op + ": " + e.Err.Error()
+}
+
+// Get the package error that caused the failure.
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
+
+// Implementation of net.Error.Timeout()
+func (e *OpError) Timeout() bool {
+	t, ok := e.Err.(net.Error)
+	return ok && t.Timeout()
+}
+
+// Implementation of net.Error.Temporary()
+func (e *OpError) Temporary() bool {
+	t, ok := e.Err.(net.Error)
+	return ok && t.Temporary()
+}
+
+// Implementation of error interface.
+func (e *OSError) Error() string {
+	return ErrSystem.msg + ": " + e.Message
+}
+
+// Get ErrSystem, so that errors.Is(err, ErrSystem) reports system
+// failures.
+func (e *OSError) Unwrap() error {
+	return ErrSystem
+}
+
+// Implementation of net.Error.Timeout()
+func (e *OSError) Timeout() bool {
+	return false
+}
+
+// Implementation of net.Error.Temporary()
+func (e *OSError) Temporary() bool {
+	return false
+}
+
+// Implementation of error interface.
+func (e *RollbackError) Error() string {
+	return e.Err.Error() + "; restoring the previous configuration failed: " + e.Rollback.Error()
+}
+
+// Get the error from the configuration change.
+func (e *RollbackError) Unwrap() error {
+	return e.Err
+}
+
+// Implementation of net.Error.Timeout()
+func (e *RollbackError) Timeout() bool {
+	return false
+}
+
+// Implementation of net.Error.Temporary()
+func (e *RollbackError) Temporary() bool {
+	return false
+}
+
+// Implementation of error interface.
+func (e *UnsupportedBitRateError) Error() string {
+	if e.Actual != 0 {
+		return fmt.Sprintf("The bit rate %d is not supported by this system or device, which set %d", e.BitRate, e.Actual)
+	}
+	return fmt.Sprintf("The bit rate %d is not supported by this system or device", e.BitRate)
+}
+
+// Implementation of net.Error.Timeout()
+func (e *UnsupportedBitRateError) Timeout() bool {
+	return false
+}
+
+// Implementation of net.Error.Temporary()
+func (e *UnsupportedBitRateError) Temporary() bool {
+	return false
+}
+
+// Get ErrUnsupportedOperation, so that errors.Is(err,
+// ErrUnsupportedOperation) reports an unsupported bit rate.
+func (e *UnsupportedBitRateError) Unwrap() error {
+	return ErrUnsupportedOperation
+}
+
+// Implementation of fmt.Stringer.
+func (v Parity) String() string {
+	switch v {
+	case PARITY_INVALID:
+		return "invalid"
+	case PARITY_NONE:
+		return "none"
+	case PARITY_ODD:
+		return "odd"
+	case PARITY_EVEN:
+		return "even"
+	case PARITY_MARK:
+		return "mark"
+	case PARITY_SPACE:
+		return "space"
+	}
+	return fmt.Sprintf("Parity(%d)", int(v))
+}
+
+// Implementation of fmt.Stringer.
+func (v RTSMode) String() string {
+	switch v {
+	case RTS_INVALID:
+		return "invalid"
+	case RTS_OFF:
+		return "off"
+	case RTS_ON:
+		return "on"
+	case RTS_FLOW_CONTROL:
+		return "flow control"
+	}
+	return fmt.Sprintf("RTSMode(%d)", int(v))
+}
+
+// Implementation of fmt.Stringer.
+func (v CTSMode) String() string {
+	switch v {
+	case CTS_INVALID:
+		return "invalid"
+	case CTS_IGNORE:
+		return "ignore"
+	case CTS_FLOW_CONTROL:
+		return "flow control"
+	}
+	return fmt.Sprintf("CTSMode(%d)", int(v))
+}
+
+// Implementation of fmt.Stringer.
+func (v DTRMode) String() string {
+	switch v {
+	case DTR_INVALID:
+		return "invalid"
+	case DTR_OFF:
+		return "off"
+	case DTR_ON:
+		return "on"
+	case DTR_FLOW_CONTROL:
+		return "flow control"
+	}
+	return fmt.Sprintf("DTRMode(%d)", int(v))
+}
+
+// Implementation of fmt.Stringer.
+func (v DSRMode) String() string {
+	switch v {
+	case DSR_INVALID:
+		return "invalid"
+	case DSR_IGNORE:
+		return "ignore"
+	case DSR_FLOW_CONTROL:
+		return "flow control"
+	}
+	return fmt.Sprintf("DSRMode(%d)", int(v))
+}
+
+// Implementation of fmt.Stringer.
+func (v XonXoffMode) String() string {
+	switch v {
+	case XONXOFF_INVALID:
+		return "invalid"
+	case XONXOFF_DISABLED:
+		return "disabled"
+	case XONXOFF_IN:
+		return "in"
+	case XONXOFF_OUT:
+		return "out"
+	case XONXOFF_INOUT:
+		return "inout"
+	}
+	return fmt.Sprintf("XonXoffMode(%d)", int(v))
+}
+
+// Implementation of fmt.Stringer.
+func (v FlowControl) String() string {
+	switch v {
+	case FLOWCONTROL_INVALID:
+		return "invalid"
+	case FLOWCONTROL_NONE:
+		return "none"
+	case FLOWCONTROL_XONXOFF:
+		return "xonxoff"
+	case FLOWCONTROL_RTSCTS:
+		return "rtscts"
+	case FLOWCONTROL_DTRDSR:
+		return "dtrdsr"
+	}
+	return fmt.Sprintf("FlowControl(%d)", int(v))
+}
+
+// Implementation of fmt.Stringer.
+func (v Transport) String() string {
+	switch v {
+	case TRANSPORT_NATIVE:
+		return "native"
+	case TRANSPORT_USB:
+		return "usb"
+	case TRANSPORT_BLUETOOTH:
+		return "bluetooth"
+	}
+	return "unknown"
+}
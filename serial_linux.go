@@ -0,0 +1,546 @@
+package serial
+
+/*
+#include <sys/ioctl.h>
+#include <termios.h>
+#include <linux/serial.h>
+#include <poll.h>
+#include "libserialport.h"
+
+// Poll a file descriptor without blocking, returning the events that
+// are pending.
+static int poll_events(int fd, int events) {
+	struct pollfd pfd = { fd, 0, 0 };
+	int result = 0;
+
+	if (events & SP_EVENT_RX_READY)
+		pfd.events |= POLLIN;
+	if (events & SP_EVENT_TX_READY)
+		pfd.events |= POLLOUT;
+
+	if (poll(&pfd, 1, 0) < 0)
+		return -1;
+
+	if (pfd.revents & POLLIN)
+		result |= SP_EVENT_RX_READY;
+	if (pfd.revents & POLLOUT)
+		result |= SP_EVENT_TX_READY;
+	if (pfd.revents & (POLLERR | POLLHUP | POLLNVAL))
+		result |= SP_EVENT_ERROR;
+
+	return result & events;
+}
+
+// Wait up to timeout milliseconds, or indefinitely if timeout is
+// negative, for a file descriptor to become readable. Returns 0 on
+// timeout.
+static int wait_readable(int fd, int timeout) {
+	struct pollfd pfd = { fd, POLLIN, 0 };
+	return poll(&pfd, 1, timeout);
+}
+
+// Check without blocking whether the other end of a file descriptor
+// has hung up.
+static int hung_up(int fd) {
+	struct pollfd pfd = { fd, 0, 0 };
+	return poll(&pfd, 1, 0) > 0 && (pfd.revents & POLLHUP);
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Issue an ioctl on a file descriptor.
+func ioctl(fd int, req uint, arg unsafe.Pointer) error {
+	_, _, e := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), uintptr(req), uintptr(arg))
+	return ioctlErr(e)
+}
+
+// Issue an ioctl that takes an integer argument.
+func ioctlInt(fd int, req uint, arg int) error {
+	_, _, e := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), uintptr(req), uintptr(arg))
+	return ioctlErr(e)
+}
+
+// Map an ioctl error to a package error.
+func ioctlErr(e syscall.Errno) error {
+	switch e {
+	case 0:
+		return nil
+	case syscall.ENOTTY, syscall.EINVAL:
+		return ErrUnsupportedOperation
+	}
+	return osError(e)
+}
+
+// Get the file descriptor of an open port.
+func (p *Port) fd() (int, error) {
+	var fd C.int
+	ret, errno := C.sp_get_port_handle(p.p, unsafe.Pointer(&fd))
+	if err := errmsg("get_port_handle", ret, errno); err != nil {
+		return -1, err
+	}
+	return int(fd), nil
+}
+
+// Set or clear the close-on-exec flag of the port file descriptor.
+func (p *Port) setCloseOnExec(enable bool) error {
+	fd, err := p.fd()
+	if err != nil {
+		return err
+	}
+
+	flags, _, e := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_GETFD, 0)
+	if e != 0 {
+		return osError(e)
+	}
+	if enable {
+		flags |= syscall.FD_CLOEXEC
+	} else {
+		flags &^= syscall.FD_CLOEXEC
+	}
+	if _, _, e := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_SETFD, flags); e != 0 {
+		return osError(e)
+	}
+
+	return nil
+}
+
+// Get the events that are pending on the port, without blocking.
+func (p *Port) pendingEvents(events int) (int, error) {
+	fd, err := p.fd()
+	if err != nil {
+		return 0, err
+	}
+
+	ev, errno := C.poll_events(C.int(fd), C.int(events))
+	if ev < 0 {
+		return 0, osError(errno)
+	}
+
+	return int(ev), nil
+}
+
+// Get the events to wait for, and how often to poll for the rest. The
+// wait reports each event as pendingEvents does, so none are polled.
+func waitMask(events int) (int, time.Duration) {
+	return events, 0
+}
+
+// Check whether the device has hung up, as after a read of zero bytes
+// that libserialport reports as a failure without an errno.
+func (p *Port) hungUp() bool {
+	fd, err := p.fd()
+	return err == nil && C.hung_up(C.int(fd)) != 0
+}
+
+// Resume output suspended by a received XOFF and send an XON to the
+// peer, according to the XON/XOFF configuration.
+func (p *Port) clearFlowHold(xon XonXoffMode) error {
+	fd, err := p.fd()
+	if err != nil {
+		return err
+	}
+
+	if xon == XONXOFF_OUT || xon == XONXOFF_INOUT {
+		if err := ioctlInt(fd, C.TCXONC, C.TCOON); err != nil {
+			return err
+		}
+	}
+	if xon == XONXOFF_IN || xon == XONXOFF_INOUT {
+		if err := ioctlInt(fd, C.TCXONC, C.TCION); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Terminal attributes of a port.
+type Termios syscall.Termios
+
+// Get the terminal attributes of an open port.
+func (p *Port) getTermios() (*Termios, error) {
+	fd, err := p.fd()
+	if err != nil {
+		return nil, err
+	}
+
+	t := new(Termios)
+	if err := ioctl(fd, syscall.TCGETS, unsafe.Pointer(t)); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Get the terminal attributes of a port that is not open.
+func readTermios(name string) (*Termios, error) {
+	fd, err := syscall.Open(name, syscall.O_RDONLY|syscall.O_NONBLOCK|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, osError(err)
+	}
+	defer syscall.Close(fd)
+
+	t := new(Termios)
+	if err := ioctl(fd, syscall.TCGETS, unsafe.Pointer(t)); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Set the terminal attributes of an open port.
+func (p *Port) setTermios(t *Termios) error {
+	fd, err := p.fd()
+	if err != nil {
+		return err
+	}
+	return ioctl(fd, syscall.TCSETS, unsafe.Pointer(t))
+}
+
+// Set how received breaks are handled.
+func (p *Port) setBreakHandling(mode int) error {
+	return p.updateTermios(func(t *Termios) {
+		t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK
+		switch mode {
+		case BREAK_IGNORE:
+			t.Iflag |= syscall.IGNBRK
+		case BREAK_MARK:
+			t.Iflag |= syscall.PARMRK
+		}
+	})
+}
+
+// Get how received breaks are handled.
+func (p *Port) breakHandling() (int, error) {
+	t, err := p.getTermios()
+	if err != nil {
+		return BREAK_INVALID, err
+	}
+
+	switch {
+	case t.Iflag&syscall.IGNBRK != 0:
+		return BREAK_IGNORE, nil
+	case t.Iflag&syscall.BRKINT != 0:
+		return BREAK_INVALID, nil
+	case t.Iflag&syscall.PARMRK != 0:
+		return BREAK_MARK, nil
+	default:
+		return BREAK_NUL, nil
+	}
+}
+
+// Set the terminal attributes of a profile.
+func (p *Port) profileTermios(profile string) error {
+	switch profile {
+	case "modem":
+		return p.updateTermios(func(t *Termios) {
+			t.Iflag |= syscall.ICRNL
+			t.Oflag |= syscall.OPOST | syscall.ONLCR
+			t.Cflag |= syscall.CLOCAL
+		})
+	case "arduino":
+		return p.updateTermios(func(t *Termios) {
+			t.Cflag &^= syscall.HUPCL
+		})
+	}
+	return nil
+}
+
+// Enable or disable input parity checking with errors marked in the
+// input.
+func (p *Port) setParityCheck(enable bool) error {
+	return p.updateTermios(func(t *Termios) {
+		parityCheck(t, enable)
+	})
+}
+
+// Enable input parity checking with errors marked in the input, for a
+// caller that restores the terminal attributes itself. Must be called
+// with cmu held.
+func (p *Port) markErrors() error {
+	return p.modifyTermios(func(t *Termios) {
+		parityCheck(t, true)
+	})
+}
+
+// Set the input flags for parity checking with errors marked in the
+// input.
+func parityCheck(t *Termios, enable bool) {
+	if enable {
+		t.Iflag |= syscall.INPCK | syscall.PARMRK
+		t.Iflag &^= syscall.IGNPAR | syscall.ISTRIP
+	} else {
+		t.Iflag &^= syscall.INPCK | syscall.PARMRK
+	}
+}
+
+// Set the VMIN and VTIME terminal attributes.
+func (p *Port) setReadPolicy(vmin, vtime int) error {
+	return p.updateTermios(func(t *Termios) {
+		t.Cc[syscall.VMIN] = uint8(vmin)
+		t.Cc[syscall.VTIME] = uint8(vtime)
+	})
+}
+
+// Get the VMIN and VTIME terminal attributes.
+func (p *Port) readPolicy() (int, int, error) {
+	t, err := p.getTermios()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(t.Cc[syscall.VMIN]), int(t.Cc[syscall.VTIME]), nil
+}
+
+// Read as a blocking read would with the VMIN and VTIME terminal
+// attributes set. The port is opened nonblocking, where the kernel
+// ignores them, and the flag is shared with writes to the port, so the
+// timing is done here by polling the descriptor instead.
+func (p *Port) policyRead(b []byte) (int, error) {
+	p.mu.Lock()
+	vmin, vtime := p.vmin, time.Duration(p.vtime)*100*time.Millisecond
+	p.mu.Unlock()
+
+	if vmin > len(b) {
+		vmin = len(b)
+	}
+
+	n := 0
+	start, last := time.Now(), time.Time{}
+	for {
+		c, err := p.readFd(b[n:])
+		switch {
+		case c > 0:
+			n += c
+			last = time.Now()
+		case err == syscall.EAGAIN:
+		case err == nil && vmin == 0 && vtime == 0:
+			// with both attributes zero, the kernel reports no data
+			// as the end of file rather than EAGAIN
+		case err == syscall.EIO, err == nil:
+			// hung up
+			if n > 0 {
+				return n, nil
+			}
+			return 0, io.EOF
+		default:
+			if e, ok := err.(syscall.Errno); ok {
+				err = osError(e)
+			}
+			return n, err
+		}
+
+		if n == len(b) || (vmin > 0 && n >= vmin) || (vmin == 0 && n > 0) {
+			return n, nil
+		}
+
+		// VTIME is an overall timer when VMIN is zero, and otherwise
+		// an inter-byte timer started by the first byte
+		ms := -1
+		if vmin == 0 || (vtime > 0 && n > 0) {
+			from := start
+			if vmin > 0 {
+				from = last
+			}
+			left := vtime - time.Since(from)
+			if left <= 0 {
+				break
+			}
+			ms = int((left + time.Millisecond - 1) / time.Millisecond)
+		}
+
+		if err := p.waitReadable(ms); err != nil {
+			return n, err
+		}
+	}
+
+	if n == 0 {
+		return 0, ErrTimeout
+	}
+	return n, nil
+}
+
+// Read from the port descriptor without blocking, holding off Close.
+func (p *Port) readFd(b []byte) (int, error) {
+	if err := p.beginIO(); err != nil {
+		return 0, err
+	}
+	defer p.iomu.RUnlock()
+
+	fd, err := p.fd()
+	if err != nil {
+		return 0, err
+	}
+	return syscall.Read(fd, b)
+}
+
+// Wait up to ms milliseconds, or indefinitely if ms is negative, for
+// the port descriptor to become readable, holding off Close. The wait
+// is cut short at ioSlice, so that Close is not held off for long.
+func (p *Port) waitReadable(ms int) error {
+	if err := p.beginIO(); err != nil {
+		return err
+	}
+	defer p.iomu.RUnlock()
+
+	fd, err := p.fd()
+	if err != nil {
+		return err
+	}
+
+	if max := int(ioSlice / time.Millisecond); ms < 0 || ms > max {
+		ms = max
+	}
+	r, errno := C.wait_readable(C.int(fd), C.int(ms))
+	if r < 0 && errno != syscall.EINTR {
+		return osError(errno)
+	}
+	return nil
+}
+
+// Attach a line discipline.
+func (p *Port) setLineDiscipline(ld int) error {
+	fd, err := p.fd()
+	if err != nil {
+		return err
+	}
+	v := C.int(ld)
+	return ioctl(fd, syscall.TIOCSETD, unsafe.Pointer(&v))
+}
+
+// Get the attached line discipline.
+func (p *Port) lineDiscipline() (int, error) {
+	fd, err := p.fd()
+	if err != nil {
+		return 0, err
+	}
+	var v C.int
+	if err := ioctl(fd, syscall.TIOCGETD, unsafe.Pointer(&v)); err != nil {
+		return 0, err
+	}
+	return int(v), nil
+}
+
+// Get the base clock rate the UART driver divides to generate the bit
+// rate.
+func (p *Port) baudBase() (int, error) {
+	fd, err := p.fd()
+	if err != nil {
+		return 0, err
+	}
+
+	var info C.struct_serial_struct
+	if err := ioctl(fd, syscall.TIOCGSERIAL, unsafe.Pointer(&info)); err != nil {
+		return 0, err
+	}
+
+	return int(info.baud_base), nil
+}
+
+// Get the state of the modem control lines.
+func (p *Port) modemLines() (int, error) {
+	fd, err := p.fd()
+	if err != nil {
+		return 0, err
+	}
+
+	var bits C.int
+	if err := ioctl(fd, syscall.TIOCMGET, unsafe.Pointer(&bits)); err != nil {
+		return 0, err
+	}
+
+	lines := 0
+	if bits&syscall.TIOCM_RTS != 0 {
+		lines |= lineRTS
+	}
+	if bits&syscall.TIOCM_DTR != 0 {
+		lines |= lineDTR
+	}
+
+	return lines, nil
+}
+
+// Check whether the driver supports the modem control lines.
+func (p *Port) supportsPinControl() (bool, error) {
+	if _, err := p.modemLines(); errors.Is(err, ErrUnsupportedOperation) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Get the name of the kernel driver bound to a port.
+func driverName(name string) (string, error) {
+	path := filepath.Join("/sys/class/tty", filepath.Base(name), "device/driver")
+	target, err := os.Readlink(path)
+	if err != nil {
+		return "", ErrUnsupportedOperation
+	}
+	return filepath.Base(target), nil
+}
+
+// Get the maximum packet size of the bulk endpoints of a USB serial
+// adapter port. usb-serial ports sit below their USB interface, and the
+// bulk endpoints of CDC ACM ports are on a sibling data interface.
+func usbPacketSize(name string) (int, error) {
+	dev := filepath.Join("/sys/class/tty", filepath.Base(name), "device")
+	patterns := []string{
+		filepath.Join(dev, "ep_*"),
+		filepath.Join(dev, "..", "ep_*"),
+		filepath.Join(dev, "..", "*:*", "ep_*"),
+	}
+
+	for _, pattern := range patterns {
+		eps, _ := filepath.Glob(pattern)
+		for _, ep := range eps {
+			if b, err := os.ReadFile(filepath.Join(ep, "type")); err != nil ||
+				strings.TrimSpace(string(b)) != "Bulk" {
+				continue
+			}
+			b, err := os.ReadFile(filepath.Join(ep, "wMaxPacketSize"))
+			if err != nil {
+				continue
+			}
+			size, err := strconv.ParseInt(strings.TrimSpace(string(b)), 16, 32)
+			if err != nil {
+				continue
+			}
+			// bits 11-12 count additional transactions per microframe
+			return int(size & 0x7ff), nil
+		}
+	}
+
+	return 0, ErrUnsupportedOperation
+}
+
+// Check whether a native port is backed by hardware. The serial8250
+// driver registers a fixed number of ports whether or not a UART is
+// present, so the UART type of those ports is read from sysfs, which
+// is zero if none was found. The device is not opened, so its modem
+// control lines are left alone.
+func isNativePortPresent(name string) bool {
+	dir := filepath.Join("/sys/class/tty", filepath.Base(name))
+	target, err := os.Readlink(dir)
+	if err != nil || !strings.Contains(target, "serial8250") {
+		return err == nil
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "type"))
+	if err != nil {
+		return false
+	}
+	uart, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	return err == nil && uart != 0
+}
@@ -0,0 +1,259 @@
+package serial
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// Open a port on the slave end of a new pseudo-terminal, returning the
+// port and the master end, which are closed when the test ends. Skips
+// the test if pseudo-terminals are not available or cannot be opened
+// as ports.
+func openPTY(t *testing.T) (*Port, *os.File) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("pseudo-terminals are not available: %v", err)
+	}
+
+	var n, unlock uint32
+	if _, _, e := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); e != 0 {
+		master.Close()
+		t.Fatal(e)
+	}
+	if _, _, e := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); e != 0 {
+		master.Close()
+		t.Fatal(e)
+	}
+
+	o := Options{Mode: MODE_READ_WRITE, BitRate: 9600}
+	p, err := o.Open(fmt.Sprintf("/dev/pts/%d", n))
+	if err != nil {
+		master.Close()
+		t.Skipf("cannot open a pseudo-terminal as a port: %v", err)
+	}
+
+	t.Cleanup(func() {
+		p.Close()
+		master.Close()
+	})
+	return p, master
+}
+
+// A read returns io.EOF once the other end of a pseudo-terminal is
+// closed, so that io.Copy loops terminate.
+func TestReadEOF(t *testing.T) {
+	p, master := openPTY(t)
+
+	if _, err := master.Write([]byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	p.SetReadDeadline(time.Now().Add(time.Second))
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(p, b); err != nil {
+		t.Fatal(err)
+	}
+
+	master.Close()
+	p.SetReadDeadline(time.Now().Add(time.Second))
+	if n, err := p.Read(b); n != 0 || err != io.EOF {
+		t.Fatalf("Read after hangup: got %d, %v, want io.EOF", n, err)
+	}
+
+	p.SetReadDeadline(time.Time{})
+	if _, err := io.Copy(ioutil.Discard, p); err != nil {
+		t.Fatalf("io.Copy after hangup: %v", err)
+	}
+}
+
+// A read with a deadline that has already passed returns ErrTimeout
+// at once on an idle port, in both read modes, and the error reports a
+// timeout as a net.Conn error would.
+func TestImmediateDeadline(t *testing.T) {
+	p, _ := openPTY(t)
+
+	b := make([]byte, 16)
+	for _, mode := range []int{READ_FILL_BUFFER, READ_FIRST_BYTE} {
+		p.SetReadMode(mode)
+		p.SetReadDeadline(time.Now())
+		start := time.Now()
+		n, err := p.Read(b)
+		if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+			t.Errorf("mode %d: read took %v", mode, elapsed)
+		}
+		if n != 0 || err != ErrTimeout {
+			t.Fatalf("mode %d: got %d, %v, want ErrTimeout", mode, n, err)
+		}
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("mode %d: %v does not match os.ErrDeadlineExceeded", mode, err)
+		}
+		var ne net.Error
+		if !errors.As(err, &ne) || !ne.Timeout() {
+			t.Errorf("mode %d: %v is not a net.Error timeout", mode, err)
+		}
+	}
+}
+
+// Closing a port stops its background goroutines and the reads in
+// progress on it, so that repeated open and close cycles leave no
+// goroutines behind.
+func TestCloseLeaks(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 20; i++ {
+		p, master := openPTY(t)
+		p.OnSignalChange(10*time.Millisecond, func(SignalState) {})
+		p.StartKeepalive([]byte("k"), 5*time.Millisecond)
+
+		read := make(chan error, 1)
+		go func() {
+			p.SetReadDeadline(time.Now().Add(10 * time.Second))
+			_, err := p.Read(make([]byte, 16))
+			read <- err
+		}()
+
+		time.Sleep(20 * time.Millisecond)
+		if err := p.Close(); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-read:
+		case <-time.After(time.Second):
+			t.Fatal("read still blocked after Close")
+		}
+		master.Close()
+	}
+
+	// goroutines may take a moment to exit after being released
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		buf := make([]byte, 1<<16)
+		t.Errorf("%d goroutines before, %d after:\n%s", before, n, buf[:runtime.Stack(buf, true)])
+	}
+}
+
+// A short read returns only what fits, leaving the rest of the
+// caller's slice alone and the remaining input waiting for the next
+// read.
+func TestShortRead(t *testing.T) {
+	p, master := openPTY(t)
+
+	in := make([]byte, 64)
+	for i := range in {
+		in[i] = byte(i)
+	}
+	if _, err := master.Write(in); err != nil {
+		t.Fatal(err)
+	}
+
+	b := bytes.Repeat([]byte{0xaa}, 64)
+	p.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := p.Read(b[:4])
+	if n != 4 || err != nil {
+		t.Fatalf("Read: got %d, %v, want 4", n, err)
+	}
+	if !bytes.Equal(b[:4], in[:4]) {
+		t.Errorf("Read: got % x, want % x", b[:4], in[:4])
+	}
+	if !bytes.Equal(b[4:], bytes.Repeat([]byte{0xaa}, 60)) {
+		t.Errorf("Read wrote past its slice: % x", b[4:])
+	}
+
+	rest := make([]byte, 60)
+	if _, err := io.ReadFull(p, rest); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rest, in[4:]) {
+		t.Errorf("remaining input: got % x, want % x", rest, in[4:])
+	}
+}
+
+// Non-standard bit rates, set with termios2, read back as set.
+func TestCustomBitRate(t *testing.T) {
+	p, _ := openPTY(t)
+
+	for _, rate := range []int{250000, 1000000} {
+		if err := p.SetBitRate(rate); err != nil {
+			t.Fatalf("SetBitRate(%d): %v", rate, err)
+		}
+		o, err := p.GetConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if o.BitRate != rate {
+			t.Errorf("SetBitRate(%d): GetConfig reports %d", rate, o.BitRate)
+		}
+		if got, err := p.BitRate(); err != nil || got != rate {
+			t.Errorf("SetBitRate(%d): BitRate reports %d, %v", rate, got, err)
+		}
+	}
+}
+
+// AT command results are parsed from a modem on the other end of a
+// pseudo-terminal: the echo and blank lines are dropped, error results
+// are reported with ErrATCommand, and a missing result times out with the lines received so far.
+func TestATCommand(t *testing.T) {
+	tests := []struct {
+		name  string
+		cmd   string
+		reply string
+		lines []string
+		err   error
+	}{
+		{"ok", "", "\r\nOK\r\n", nil, nil},
+		{"echo", "+CSQ", "AT+CSQ\r\r\n+CSQ: 21,99\r\n\r\nOK\r\n", []string{"+CSQ: 21,99"}, nil},
+		{"lines", "I", "Modem\r\nRevision 1\r\nOK\r\n", []string{"Modem", "Revision 1"}, nil},
+		{"error", "+FOO", "\r\nERROR\r\n", []string{"ERROR"}, ErrATCommand},
+		{"cme error", "+CPIN?", "\r\n+CME ERROR: 10\r\n", []string{"+CME ERROR: 10"}, ErrATCommand},
+		{"timeout", "+COPS=?", "\r\n+COPS: (2,\"Net\")\r\n", []string{"+COPS: (2,\"Net\")"}, ErrTimeout},
+	}
+
+	p, master := openPTY(t)
+	for _, tt := range tests {
+		// answer the command once all of it has been received
+		want := "AT" + tt.cmd + "\r"
+		done := make(chan error, 1)
+		go func(reply string) {
+			var got []byte
+			b := make([]byte, 64)
+			for !bytes.HasSuffix(got, []byte("\r")) {
+				n, err := master.Read(b)
+				if err != nil {
+					done <- err
+					return
+				}
+				got = append(got, b[:n]...)
+			}
+			if string(got) != want {
+				done <- fmt.Errorf("modem received %q, want %q", got, want)
+				return
+			}
+			_, err := master.Write([]byte(reply))
+			done <- err
+		}(tt.reply)
+
+		lines, err := p.ATCommand(tt.cmd, 300*time.Millisecond)
+		if err != tt.err {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, tt.err)
+		}
+		if !reflect.DeepEqual(lines, tt.lines) {
+			t.Errorf("%s: got lines %q, want %q", tt.name, lines, tt.lines)
+		}
+		if err := <-done; err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+//go:build !linux
+// +build !linux
+
+package serial
+
+import "time"
+
+// Software flow control resume character.
+const xonChar = 0x11
+
+// Get the name of the kernel driver bound to a port.
+func driverName(name string) (string, error) {
+	return "", ErrUnsupportedOperation
+}
+
+// Get the maximum packet size of the bulk endpoints of a USB serial
+// adapter port.
+func usbPacketSize(name string) (int, error) {
+	return 0, ErrUnsupportedOperation
+}
+
+// Check whether a native port is backed by hardware.
+func isNativePortPresent(name string) bool {
+	return true
+}
+
+// Set or clear the close-on-exec flag of the port file descriptor.
+func (p *Port) setCloseOnExec(enable bool) error {
+	return ErrUnsupportedOperation
+}
+
+// How often to check for an empty output buffer while waiting for the
+// port to be ready to transmit.
+const txPollInterval = 10 * time.Millisecond
+
+// Get the events that are pending on the port, without blocking. The
+// port is taken to be ready to transmit when its output buffer is
+// empty, and errors are not detected.
+func (p *Port) pendingEvents(events int) (int, error) {
+	ev := 0
+	if events&EVENT_RX_READY != 0 {
+		if n, err := p.InputWaiting(); err != nil {
+			return 0, err
+		} else if n > 0 {
+			ev |= EVENT_RX_READY
+		}
+	}
+	if events&EVENT_TX_READY != 0 {
+		if n, err := p.OutputWaiting(); err != nil {
+			return 0, err
+		} else if n == 0 {
+			ev |= EVENT_TX_READY
+		}
+	}
+	return ev, nil
+}
+
+// Get the events to wait for, and how often to poll for the rest. The
+// wait reports the port ready to transmit as soon as there is room in
+// the output buffer rather than once it is empty, and would return at
+// once until it drains, so an empty buffer is polled for instead.
+func waitMask(events int) (int, time.Duration) {
+	if events&EVENT_TX_READY != 0 {
+		return events &^ EVENT_TX_READY, txPollInterval
+	}
+	return events, 0
+}
+
+// Check whether the device has hung up. The end of file cannot be told
+// apart from other failures on this platform.
+func (p *Port) hungUp() bool {
+	return false
+}
+
+// Get the state of the modem control lines.
+func (p *Port) modemLines() (int, error) {
+	return 0, ErrUnsupportedOperation
+}
+
+// Check whether the driver supports the modem control lines.
+func (p *Port) supportsPinControl() (bool, error) {
+	return false, ErrUnsupportedOperation
+}
+
+// Terminal attributes of a port.
+type Termios struct{}
+
+// Get the terminal attributes of an open port.
+func (p *Port) getTermios() (*Termios, error) {
+	return nil, ErrUnsupportedOperation
+}
+
+// Get the terminal attributes of a port that is not open.
+func readTermios(name string) (*Termios, error) {
+	return nil, ErrUnsupportedOperation
+}
+
+// Set the terminal attributes of an open port.
+func (p *Port) setTermios(t *Termios) error {
+	return ErrUnsupportedOperation
+}
+
+// Send an XON to the peer if input flow control is enabled. Output
+// suspended by a received XOFF cannot be resumed on this platform.
+func (p *Port) clearFlowHold(xon XonXoffMode) error {
+	if xon == XONXOFF_IN || xon == XONXOFF_INOUT {
+		if _, err := p.TryWrite([]byte{xonChar}); err != nil {
+			return err
+		}
+	}
+	if xon == XONXOFF_OUT || xon == XONXOFF_INOUT {
+		return ErrUnsupportedOperation
+	}
+	return nil
+}
+
+// Set how received breaks are handled.
+func (p *Port) setBreakHandling(mode int) error {
+	return ErrUnsupportedOperation
+}
+
+// Get how received breaks are handled.
+func (p *Port) breakHandling() (int, error) {
+	return BREAK_INVALID, ErrUnsupportedOperation
+}
+
+// Get the base clock rate the UART driver divides to generate the bit
+// rate.
+func (p *Port) baudBase() (int, error) {
+	return 0, ErrUnsupportedOperation
+}
+
+// Set the VMIN and VTIME terminal attributes.
+func (p *Port) setReadPolicy(vmin, vtime int) error {
+	return ErrUnsupportedOperation
+}
+
+// Get the VMIN and VTIME terminal attributes.
+func (p *Port) readPolicy() (int, int, error) {
+	return 0, 0, ErrUnsupportedOperation
+}
+
+// Read with a single blocking call into the kernel.
+func (p *Port) policyRead(b []byte) (int, error) {
+	return 0, ErrUnsupportedOperation
+}
+
+// Set the terminal attributes of a profile.
+func (p *Port) profileTermios(profile string) error {
+	return ErrUnsupportedOperation
+}
+
+// Enable or disable input parity checking with errors marked in the
+// input.
+func (p *Port) setParityCheck(enable bool) error {
+	return ErrUnsupportedOperation
+}
+
+// Enable input parity checking with errors marked in the input. Must be
+// called with cmu held.
+func (p *Port) markErrors() error {
+	return ErrUnsupportedOperation
+}
+
+// Attach a line discipline.
+func (p *Port) setLineDiscipline(ld int) error {
+	return ErrUnsupportedOperation
+}
+
+// Get the attached line discipline.
+func (p *Port) lineDiscipline() (int, error) {
+	return 0, ErrUnsupportedOperation
+}
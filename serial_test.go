@@ -0,0 +1,552 @@
+package serial
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Run each function repeatedly in its own goroutine and wait for them
+// all, so that the race detector sees them overlap.
+func concurrently(n int, fns ...func()) {
+	var wg sync.WaitGroup
+	for _, fn := range fns {
+		wg.Add(1)
+		go func(fn func()) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				fn()
+			}
+		}(fn)
+	}
+	wg.Wait()
+}
+
+// The port state is safe to use from several goroutines. The port is
+// not open, so no device is needed; run with -race.
+func TestConcurrentState(t *testing.T) {
+	p, err := newPort(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	concurrently(100,
+		func() {
+			p.SetDeadline(time.Now())
+			p.ReadDeadline()
+			p.WriteDeadline()
+		},
+		func() {
+			p.SetDefaultTimeout(time.Millisecond)
+			p.deadline(time.Time{})
+		},
+		func() {
+			p.SetReadMode(READ_FIRST_BYTE)
+			p.SetMinReadInterval(0)
+			p.paceRead()
+		},
+		func() {
+			p.SetMaxReadSize(16)
+			p.SetLineTerminator([]byte("\n"))
+			p.lineTerminator()
+			p.SetTxByteDelay(0)
+		},
+		func() {
+			p.SetEchoCancel(true)
+			p.expectEcho([]byte("echo"))
+			p.stripEcho([]byte("echo"))
+		},
+		func() {
+			p.dropBuffered()
+			p.buffered()
+			p.takeBuffered(make([]byte, 1))
+		},
+		func() {
+			p.SetDataBits(8)
+			p.SetParity(PARITY_NONE)
+		},
+		func() {
+			p.IsOpen()
+			p.BitRate()
+			p.Parity()
+			p.XonXoff()
+		},
+		func() {
+			p.Close()
+		},
+	)
+}
+
+// Reads, writes, configuration changes and queries on an open port are
+// safe to make from several goroutines. Set SERIAL_TEST_PORT to the
+// name of a port to run it; run with -race.
+func TestConcurrentPort(t *testing.T) {
+	name := os.Getenv("SERIAL_TEST_PORT")
+	if name == "" {
+		t.Skip("SERIAL_TEST_PORT is not set")
+	}
+
+	o := Options{Mode: MODE_READ_WRITE, BitRate: 9600}
+	p, err := o.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	b := make([]byte, 16)
+	concurrently(50,
+		func() {
+			p.ReadTimeout(b, time.Millisecond)
+		},
+		func() {
+			p.WriteTimeout([]byte("hello"), 10*time.Millisecond)
+		},
+		func() {
+			p.Apply(&Options{BitRate: 9600})
+			p.ApplyAtomic(&Options{DataBits: 8})
+			p.SetBitRate(9600)
+		},
+		func() {
+			p.GetConfig()
+			p.FlowControlDetail()
+			p.InputWaiting()
+		},
+		func() {
+			p.SetDeadline(time.Now().Add(time.Millisecond))
+			p.SetReadMode(READ_FIRST_BYTE)
+			p.ResetInput()
+		},
+	)
+
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if p.IsOpen() {
+		t.Fatal("port is open after Close")
+	}
+	if _, err := p.BitRate(); err != ErrNotOpen {
+		t.Fatalf("BitRate after Close: got %v, want ErrNotOpen", err)
+	}
+}
+
+// Close does not wait for a read without a deadline: the read returns
+// ErrNotOpen once its current call into libserialport returns, and the
+// port is closed. Set SERIAL_TEST_PORT to the name of a port with
+// nothing to receive to run it.
+func TestCloseDuringRead(t *testing.T) {
+	name := os.Getenv("SERIAL_TEST_PORT")
+	if name == "" {
+		t.Skip("SERIAL_TEST_PORT is not set")
+	}
+
+	o := Options{Mode: MODE_READ_WRITE, BitRate: 9600}
+	p, err := o.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	read := make(chan error, 1)
+	go func() {
+		_, err := p.Read(make([]byte, 16))
+		read <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Close took %v", d)
+	}
+
+	select {
+	case err := <-read:
+		if err != ErrNotOpen {
+			t.Errorf("Read during Close: got %v, want ErrNotOpen", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read was not interrupted by Close")
+	}
+}
+
+// The configuration getters report ErrNotOpen on a port that is not
+// open, rather than an error from libserialport.
+func TestGettersNotOpen(t *testing.T) {
+	p, err := newPort(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.IsOpen() {
+		t.Fatal("new port is open")
+	}
+
+	getters := map[string]func() error{
+		"BitRate":           func() error { _, err := p.BitRate(); return err },
+		"DataBits":          func() error { _, err := p.DataBits(); return err },
+		"StopBits":          func() error { _, err := p.StopBits(); return err },
+		"Parity":            func() error { _, err := p.Parity(); return err },
+		"RTS":               func() error { _, err := p.RTS(); return err },
+		"CTS":               func() error { _, err := p.CTS(); return err },
+		"DTR":               func() error { _, err := p.DTR(); return err },
+		"DSR":               func() error { _, err := p.DSR(); return err },
+		"XonXoff":           func() error { _, err := p.XonXoff(); return err },
+		"FlowControlDetail": func() error { _, err := p.FlowControlDetail(); return err },
+		"GetConfig":         func() error { _, err := p.GetConfig(); return err },
+	}
+	for name, get := range getters {
+		if err := get(); err != ErrNotOpen {
+			t.Errorf("%s: got %v, want ErrNotOpen", name, err)
+		}
+	}
+}
+
+// A port that fails to close is left open and still counted, so that
+// Close can be retried, and Close on a port that is not open does
+// nothing.
+func TestCloseFailure(t *testing.T) {
+	p, err := newPort(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// without a handle, sp_close fails
+	p.setOpen(true)
+	p.done = make(chan struct{})
+	n := OpenPortCount()
+
+	if err := p.Close(); err == nil {
+		t.Fatal("Close succeeded without a port handle")
+	}
+	if !p.IsOpen() {
+		t.Fatal("port is not open after a failed Close")
+	}
+	if OpenPortCount() != n {
+		t.Fatalf("open port count changed from %d to %d", n, OpenPortCount())
+	}
+
+	p.setOpen(false)
+	for i := 0; i < 2; i++ {
+		if err := p.Close(); err != nil {
+			t.Fatalf("Close of a closed port: %v", err)
+		}
+	}
+}
+
+// Close is safe to call while other goroutines query the port. The
+// port has no handle, so every Close fails and leaves it open; run with
+// -race.
+func TestConcurrentClose(t *testing.T) {
+	p, err := newPort(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.setOpen(true)
+	p.done = make(chan struct{})
+
+	concurrently(100,
+		func() { p.Close() },
+		func() { p.Close() },
+		func() { p.IsOpen() },
+		func() { p.BitRate() },
+		func() { p.GetConfig() },
+		func() { p.Signals() },
+	)
+
+	p.setOpen(false)
+}
+
+// Close called from a callback returns rather than waiting for the
+// goroutine running the callback.
+func TestCloseFromCallback(t *testing.T) {
+	p, err := newPort(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.setOpen(true)
+	p.done = make(chan struct{})
+
+	closed := make(chan error, 1)
+	p.spawn(func(done <-chan struct{}) {
+		p.callback(func() {
+			closed <- p.Close()
+		})
+		<-done
+	})
+
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close from a callback did not return")
+	}
+
+	p.wg.Wait()
+	p.setOpen(false)
+}
+
+// Deadlines are rounded up to whole milliseconds, so that a deadline
+// less than a millisecond away still blocks rather than being taken as
+// expired, and a deadline that has passed gives zero, which the callers
+// take as expired rather than as no timeout.
+func TestDeadline2Millis(t *testing.T) {
+	tests := []struct {
+		name string
+		in   time.Duration
+		min  int64
+		max  int64
+	}{
+		{"past", -time.Second, 0, 0},
+		{"nanosecond", time.Nanosecond, 0, 1},
+		{"submillisecond", 500 * time.Microsecond, 1, 1},
+		{"fractional", 1500 * time.Microsecond, 2, 2},
+		{"seconds", 2 * time.Second, 2000, 2000},
+	}
+	for _, tt := range tests {
+		if got := deadline2millis(time.Now().Add(tt.in)); got < tt.min || got > tt.max {
+			t.Errorf("%s: got %d, want %d to %d", tt.name, got, tt.min, tt.max)
+		}
+	}
+
+	if got := deadline2millis(time.Time{}); got != 0 {
+		t.Errorf("zero time: got %d, want 0", got)
+	}
+}
+
+// A port that is already open cannot be opened again, which would leak
+// the first handle.
+func TestOpenTwice(t *testing.T) {
+	p, err := newPort(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.setOpen(true)
+	if err := p.open(MODE_READ); err != ErrAlreadyOpen {
+		t.Errorf("open of an open port: got %v, want ErrAlreadyOpen", err)
+	}
+	p.setOpen(false)
+}
+
+// Reads of nothing return at once without touching the port, with or
+// without a deadline.
+func TestEmptyRead(t *testing.T) {
+	p, err := newPort(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.mode = MODE_READ_WRITE
+
+	for _, deadline := range []time.Time{{}, time.Now().Add(time.Second)} {
+		p.SetReadDeadline(deadline)
+		if n, err := p.Read(nil); n != 0 || err != nil {
+			t.Errorf("Read(nil): got %d, %v", n, err)
+		}
+		if n, err := p.Read([]byte{}); n != 0 || err != nil {
+			t.Errorf("Read([]byte{}): got %d, %v", n, err)
+		}
+	}
+}
+
+// Writes of nothing return at once without touching the port, with or
+// without a deadline.
+func TestEmptyWrite(t *testing.T) {
+	p, err := newPort(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.mode = MODE_READ_WRITE
+
+	for _, deadline := range []time.Time{{}, time.Now().Add(time.Second)} {
+		p.SetWriteDeadline(deadline)
+		if n, err := p.Write(nil); n != 0 || err != nil {
+			t.Errorf("Write(nil): got %d, %v", n, err)
+		}
+		if n, err := p.Write([]byte{}); n != 0 || err != nil {
+			t.Errorf("Write([]byte{}): got %d, %v", n, err)
+		}
+	}
+}
+
+// The enum types print their names, and their numeric values when
+// they are not known.
+func TestEnumString(t *testing.T) {
+	tests := []struct {
+		in   fmt.Stringer
+		want string
+	}{
+		{PARITY_INVALID, "invalid"},
+		{PARITY_NONE, "none"},
+		{PARITY_ODD, "odd"},
+		{PARITY_EVEN, "even"},
+		{PARITY_MARK, "mark"},
+		{PARITY_SPACE, "space"},
+		{Parity(99), "Parity(99)"},
+		{RTS_INVALID, "invalid"},
+		{RTS_OFF, "off"},
+		{RTS_ON, "on"},
+		{RTS_FLOW_CONTROL, "flow control"},
+		{RTSMode(99), "RTSMode(99)"},
+		{CTS_INVALID, "invalid"},
+		{CTS_IGNORE, "ignore"},
+		{CTS_FLOW_CONTROL, "flow control"},
+		{CTSMode(99), "CTSMode(99)"},
+		{DTR_INVALID, "invalid"},
+		{DTR_OFF, "off"},
+		{DTR_ON, "on"},
+		{DTR_FLOW_CONTROL, "flow control"},
+		{DTRMode(99), "DTRMode(99)"},
+		{DSR_INVALID, "invalid"},
+		{DSR_IGNORE, "ignore"},
+		{DSR_FLOW_CONTROL, "flow control"},
+		{DSRMode(99), "DSRMode(99)"},
+		{XONXOFF_INVALID, "invalid"},
+		{XONXOFF_DISABLED, "disabled"},
+		{XONXOFF_IN, "in"},
+		{XONXOFF_OUT, "out"},
+		{XONXOFF_INOUT, "inout"},
+		{XonXoffMode(99), "XonXoffMode(99)"},
+		{FLOWCONTROL_INVALID, "invalid"},
+		{FLOWCONTROL_NONE, "none"},
+		{FLOWCONTROL_XONXOFF, "xonxoff"},
+		{FLOWCONTROL_RTSCTS, "rtscts"},
+		{FLOWCONTROL_DTRDSR, "dtrdsr"},
+		{FlowControl(99), "FlowControl(99)"},
+	}
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("%T(%v): got %q, want %q", tt.in, tt.in, got, tt.want)
+		}
+	}
+}
+
+// The transport prints its name, and "unknown" when it is not known.
+func TestTransportString(t *testing.T) {
+	tests := []struct {
+		in   Transport
+		want string
+	}{
+		{TRANSPORT_NATIVE, "native"},
+		{TRANSPORT_USB, "usb"},
+		{TRANSPORT_BLUETOOTH, "bluetooth"},
+		{Transport(99), "unknown"},
+	}
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("%d: got %q, want %q", int(tt.in), got, tt.want)
+		}
+	}
+}
+
+// An unsupported bit rate is reported as an unsupported operation, and
+// can be told apart from other unsupported operations.
+func TestUnsupportedBitRateError(t *testing.T) {
+	for _, err := range []error{
+		&UnsupportedBitRateError{BitRate: 1234567},
+		&UnsupportedBitRateError{BitRate: 1234567, Actual: 1250000},
+		bitRateErr(&OpError{Op: "set_baudrate", Err: ErrUnsupportedOperation}, 1234567),
+	} {
+		if !errors.Is(err, ErrUnsupportedOperation) {
+			t.Errorf("%v: not ErrUnsupportedOperation", err)
+		}
+		var e *UnsupportedBitRateError
+		if !errors.As(err, &e) || e.BitRate != 1234567 {
+			t.Errorf("%v: not an UnsupportedBitRateError for 1234567", err)
+		}
+	}
+
+	if err := bitRateErr(ErrInvalidArguments, 9600); err != ErrInvalidArguments {
+		t.Errorf("bitRateErr(ErrInvalidArguments): got %v", err)
+	}
+}
+
+// A failed rollback reports both errors, and still matches the error
+// from the configuration change.
+func TestRollbackError(t *testing.T) {
+	err := error(&RollbackError{Err: &UnsupportedBitRateError{BitRate: 1234567}, Rollback: ErrNotOpen})
+	if !errors.Is(err, ErrUnsupportedOperation) {
+		t.Errorf("%v: not ErrUnsupportedOperation", err)
+	}
+	var e *RollbackError
+	if !errors.As(err, &e) || e.Rollback != ErrNotOpen {
+		t.Errorf("%v: rollback error not reported", err)
+	}
+}
+
+// Only timeouts match os.ErrDeadlineExceeded, including when wrapped.
+func TestErrorIs(t *testing.T) {
+	tests := []struct {
+		err     error
+		timeout bool
+	}{
+		{ErrTimeout, true},
+		{&OpError{Op: "read", Err: ErrTimeout}, true},
+		{ErrNotOpen, false},
+		{ErrUnsupportedOperation, false},
+		{&OpError{Op: "read", Err: ErrSystem}, false},
+	}
+	for _, tt := range tests {
+		if got := errors.Is(tt.err, os.ErrDeadlineExceeded); got != tt.timeout {
+			t.Errorf("%v: errors.Is(os.ErrDeadlineExceeded) = %v, want %v", tt.err, got, tt.timeout)
+		}
+	}
+}
+
+// A break can be started and ended on a port that supports it, and
+// the port is usable afterwards. Set SERIAL_TEST_PORT to the name of a
+// port with its transmit and receive lines looped back to run it.
+func TestBreak(t *testing.T) {
+	name := os.Getenv("SERIAL_TEST_PORT")
+	if name == "" {
+		t.Skip("SERIAL_TEST_PORT is not set")
+	}
+
+	o := Options{Mode: MODE_READ_WRITE, BitRate: 9600}
+	p, err := o.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	if err := p.StartBreak(); errors.Is(err, ErrUnsupportedOperation) {
+		t.Skip("breaks are not supported")
+	} else if err != nil {
+		t.Fatalf("StartBreak: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := p.EndBreak(); err != nil {
+		t.Fatalf("EndBreak: %v", err)
+	}
+
+	// drop the break received on the loopback before checking the line
+	time.Sleep(10 * time.Millisecond)
+	if err := p.ResetInput(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.WriteTimeout([]byte("ok"), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 2)
+	if n, err := p.ReadTimeout(b, time.Second); err != nil || string(b[:n]) != "ok" {
+		t.Fatalf("after break: got %q, %v, want \"ok\"", b[:n], err)
+	}
+}
+
+// A timeout of zero or less means no deadline, as the zero time does
+// for SetDeadline, rather than an immediate one.
+func TestTimeoutDeadline(t *testing.T) {
+	for _, d := range []time.Duration{0, -time.Second} {
+		if deadline := timeoutDeadline(d); !deadline.IsZero() {
+			t.Errorf("timeoutDeadline(%v) = %v, want the zero time", d, deadline)
+		}
+	}
+	if deadline := timeoutDeadline(time.Second); !deadline.After(time.Now()) {
+		t.Errorf("timeoutDeadline(1s) = %v, want a time in the future", deadline)
+	}
+}
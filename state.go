@@ -0,0 +1,66 @@
+package serial
+
+import (
+	"encoding/json"
+)
+
+// Saved port state. The device identity is used to find the port again
+// when its name changes, such as when a USB adapter is enumerated in a
+// different order.
+type portState struct {
+	Name    string  `json:"name"`
+	VID     int     `json:"vid,omitempty"`
+	PID     int     `json:"pid,omitempty"`
+	Serial  string  `json:"serial,omitempty"`
+	Options Options `json:"options"`
+}
+
+// Export the port's device identity and configuration, so that a
+// supervisor can persist them and restore the port with ImportState
+// after a restart. The data is JSON.
+func (p *Port) ExportState() ([]byte, error) {
+	options, err := p.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	state := portState{Name: p.Name(), Options: options}
+	if vid, pid, err := p.USBVIDPID(); err == nil {
+		state.VID, state.PID = vid, pid
+		state.Serial = p.USBSerialNumber()
+	}
+
+	return json.Marshal(&state)
+}
+
+// Open a port with the state saved by ExportState. If name is empty,
+// a USB adapter is found by its vendor ID, product ID and serial number
+// if they were saved, falling back to the saved name.
+func ImportState(name string, data []byte) (*Port, error) {
+	var state portState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, ErrInvalidArguments
+	}
+
+	if name == "" {
+		name = state.Name
+		if state.VID != 0 && state.Serial != "" {
+			if info, err := findUSBPort(state.VID, state.PID, state.Serial); err == nil {
+				name = info.Name()
+			}
+		}
+	}
+
+	return state.Options.Open(name)
+}
+
+// Find the USB adapter port with the given identity.
+func findUSBPort(vid, pid int, serial string) (*Info, error) {
+	matches, err := FindPorts(PortFilter{VID: vid, PID: pid, Serial: serial})
+	if err != nil {
+		return nil, err
+	} else if len(matches) == 0 {
+		return nil, ErrNoPort
+	}
+	return matches[0].Info, nil
+}